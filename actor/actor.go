@@ -0,0 +1,128 @@
+package actor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrActorExists    = errors.New("actor: already exists")
+	ErrActorNotFound  = errors.New("actor: not found")
+	ErrActorStopped   = errors.New("actor: stopped")
+	ErrActorPanic     = errors.New("actor: panic")
+	ErrTimeOut        = errors.New("actor: timeout")
+	ErrNoRPC          = errors.New("actor: remote actor without rpc")
+	ErrMailboxDropped = errors.New("actor: mailbox dropped by worker")
+)
+
+// actor地址，ServerID为actor所在的服务器
+type PID struct {
+	ServerID int32
+	ID       string
+}
+
+func NewPID(serverID int32, id string) *PID {
+	return &PID{ServerID: serverID, ID: id}
+}
+
+func (p *PID) String() string {
+	return fmt.Sprintf("%d/%s", p.ServerID, p.ID)
+}
+
+// actor的状态只在Receive中访问，同一个actor的消息顺序执行
+type Actor interface {
+	Receive(ctx *Context)
+}
+
+// 可选的生命周期回调，启动、重启后调用Started，停止、重启前调用Stopped
+type Starter interface {
+	Started(ctx *Context)
+}
+
+type Stopper interface {
+	Stopped(ctx *Context)
+}
+
+type Props struct {
+	Producer func() Actor
+
+	// RestartWindow内panic超过MaxRestarts次时停止actor，MaxRestarts为0时使用默认值，小于0时不重启
+	MaxRestarts   int
+	RestartWindow time.Duration
+	Throughput    int //每次调度最多处理的消息数，避免长时间占用worker
+}
+
+const (
+	DefaultMaxRestarts   = 3
+	DefaultRestartWindow = time.Minute
+	DefaultThroughput    = 100
+)
+
+func (p Props) withDefault() Props {
+	if p.MaxRestarts == 0 {
+		p.MaxRestarts = DefaultMaxRestarts
+	}
+	if p.RestartWindow <= 0 {
+		p.RestartWindow = DefaultRestartWindow
+	}
+	if p.Throughput <= 0 {
+		p.Throughput = DefaultThroughput
+	}
+	return p
+}
+
+type Context struct {
+	system  *System
+	self    *PID
+	sender  *PID
+	msg     proto.Message
+	respond func(proto.Message, error)
+}
+
+func (p *Context) System() *System {
+	return p.system
+}
+
+func (p *Context) Self() *PID {
+	return p.self
+}
+
+// 发送方actor，外部发送时为nil
+func (p *Context) Sender() *PID {
+	return p.sender
+}
+
+// Started、Stopped回调中为nil
+func (p *Context) Message() proto.Message {
+	return p.msg
+}
+
+// 回复Request，Send发来的消息调用无效
+func (p *Context) Respond(msg proto.Message) {
+	if p.respond != nil {
+		p.respond(msg, nil)
+		p.respond = nil
+	}
+}
+
+// 以当前actor为发送方发送消息
+func (p *Context) Send(pid *PID, msg proto.Message) error {
+	return p.system.send(pid, p.self, msg)
+}
+
+// 异步请求，cb在当前actor的mailbox中执行，可以直接访问actor的状态
+func (p *Context) Request(pid *PID, msg proto.Message, timeout time.Duration, cb func(proto.Message, error)) {
+	self := p.self
+	p.system.requestAsync(pid, self, msg, timeout, func(resp proto.Message, err error) {
+		p.system.post(self, func() {
+			cb(resp, err)
+		})
+	})
+}
+
+func (p *Context) Stop() {
+	p.system.Stop(p.self)
+}
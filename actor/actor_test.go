@@ -0,0 +1,187 @@
+package actor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type counter struct {
+	n int32
+}
+
+func (p *counter) Receive(ctx *Context) {
+	switch msg := ctx.Message().(type) {
+	case *wrapperspb.Int32Value:
+		if msg.Value < 0 {
+			panic("negative")
+		}
+		p.n += msg.Value
+	case *wrapperspb.StringValue:
+		ctx.Respond(wrapperspb.Int32(p.n))
+	}
+}
+
+func TestActorLocal(t *testing.T) {
+	w := natsrpc.NewShardedWorker(4)
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	system := NewSystem(w, nil)
+	pid, err := system.Spawn("counter", Props{Producer: func() Actor { return &counter{} }})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := system.Spawn("counter", Props{Producer: func() Actor { return &counter{} }}); err != ErrActorExists {
+		t.Errorf("spawn twice error: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		system.Send(pid, wrapperspb.Int32(1))
+	}
+	resp, err := system.Request(pid, wrapperspb.String("get"), time.Second)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !proto.Equal(resp, wrapperspb.Int32(1000)) {
+		t.Errorf("count not equal: %v", resp)
+	}
+
+	// panic后重启，状态重置
+	system.Send(pid, wrapperspb.Int32(-1))
+	resp, err = system.Request(pid, wrapperspb.String("get"), time.Second)
+	if err != nil || !proto.Equal(resp, wrapperspb.Int32(0)) {
+		t.Errorf("restart result not equal: %v %v", resp, err)
+	}
+
+	system.Stop(pid)
+	if _, err := system.Request(pid, wrapperspb.String("get"), time.Second); err != ErrActorNotFound && err != ErrActorStopped {
+		t.Errorf("request stopped actor error: %v", err)
+	}
+}
+
+// 应答后panic的actor
+type respondPanic struct{}
+
+func (p *respondPanic) Receive(ctx *Context) {
+	if _, ok := ctx.Message().(*wrapperspb.StringValue); ok {
+		ctx.Respond(wrapperspb.String("ok"))
+		panic("after respond")
+	}
+}
+
+func TestActorRemote(t *testing.T) {
+	w := natsrpc.NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	system := NewSystem(w, nil)
+	produced := 0
+	system.Spawn("remote", Props{Producer: func() Actor {
+		produced++
+		return &respondPanic{}
+	}})
+	if _, err := system.Spawn("remote", Props{Producer: func() Actor {
+		t.Error("producer called for duplicate id")
+		return &respondPanic{}
+	}}); err != ErrActorExists {
+		t.Errorf("spawn twice error: %v", err)
+	}
+
+	type answer struct {
+		resp *rpcmsg.ActorResp
+	}
+	answers := make(chan answer, 4)
+	request := func(target string, msg proto.Message) {
+		body, _ := anypb.New(msg)
+		system.handleRemote(&rpcmsg.ActorMsg{Target: target, Body: body, Senderserver: 2, Senderid: "caller"}, func(resp proto.Message, err error) {
+			answers <- answer{resp: makeActorResp(resp, err)}
+		})
+	}
+
+	// 应答后panic只回复一次
+	request("remote", wrapperspb.String("get"))
+	a := <-answers
+	resp, err := parseActorResp(a.resp)
+	if err != nil || !proto.Equal(resp, wrapperspb.String("ok")) {
+		t.Errorf("remote resp not equal: %v %v", resp, err)
+	}
+	request("missing", wrapperspb.String("get"))
+	a = <-answers
+	if _, err := parseActorResp(a.resp); err == nil || err.Error() != ErrActorNotFound.Error() {
+		t.Errorf("missing actor error: %v", err)
+	}
+	select {
+	case a := <-answers:
+		t.Errorf("answered twice: %v", a.resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 远程停止
+	system.handleRemote(&rpcmsg.ActorMsg{Target: "remote", Stop: true}, nil)
+	if _, err := system.Request(NewPID(0, "remote"), wrapperspb.String("get"), 0); err != ErrActorNotFound && err != ErrActorStopped {
+		t.Errorf("request stopped actor error: %v", err)
+	}
+	// panic后重启调用了Producer
+	if produced != 2 {
+		t.Errorf("produced not equal: %d", produced)
+	}
+}
+
+// 不应答的actor超时后返回ErrTimeOut
+func TestActorRequestTimeout(t *testing.T) {
+	w := natsrpc.NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	system := NewSystem(w, nil)
+	pid, _ := system.Spawn("silent", Props{Producer: func() Actor { return &counter{} }})
+	done := make(chan error, 1)
+	system.requestAsync(pid, nil, wrapperspb.Int32(1), 20*time.Millisecond, func(resp proto.Message, err error) {
+		done <- err
+	})
+	select {
+	case err := <-done:
+		if err != ErrTimeOut {
+			t.Errorf("expect ErrTimeOut, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("request never finished")
+	}
+}
+
+// worker丢弃或拒绝投递时请求立即收到错误，不用等到超时
+func TestActorScheduleFailed(t *testing.T) {
+	w := natsrpc.NewWorker()
+	system := NewSystem(w, nil)
+	pid, _ := system.Spawn("dropped", Props{Producer: func() Actor { return &counter{} }})
+
+	done := make(chan error, 2)
+	system.requestAsync(pid, nil, wrapperspb.Int32(1), time.Minute, func(resp proto.Message, err error) {
+		done <- err
+	})
+	// 没有Run时关闭，队列中的任务被丢弃
+	w.Close()
+	system.requestAsync(pid, nil, wrapperspb.Int32(2), time.Minute, func(resp proto.Message, err error) {
+		done <- err
+	})
+
+	for _, expect := range []error{ErrMailboxDropped, natsrpc.ErrWorkerClosed} {
+		select {
+		case err := <-done:
+			if err != expect {
+				t.Errorf("expect %v, got %v", expect, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("request never finished")
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package actor
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"github.com/wwqdrh/natsrpc"
+	"google.golang.org/protobuf/proto"
+)
+
+type envelope struct {
+	sender  *PID
+	msg     proto.Message
+	respond func(proto.Message, error)
+	fn      func() //内部回调，如Started、异步请求的应答
+	stop    bool
+}
+
+// 每个actor一个mailbox，有消息时投递到worker中处理，同一时间最多只有一个处理任务
+type process struct {
+	system *System
+	pid    *PID
+	props  Props
+	key    uint32
+	actor  Actor
+
+	mu        sync.Mutex
+	mailbox   []envelope
+	scheduled bool
+	stopped   bool
+
+	restarts []time.Time
+}
+
+func newProcess(system *System, pid *PID, props Props) *process {
+	return &process{
+		system: system,
+		pid:    pid,
+		props:  props,
+		key:    natsrpc.CRC32Hash(pid.ID),
+	}
+}
+
+// 第一个任务，在worker中创建actor
+func (p *process) start() {
+	p.actor = p.props.Producer()
+	p.onStarted()
+}
+
+func (p *process) tell(env envelope) error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return ErrActorStopped
+	}
+	p.mailbox = append(p.mailbox, env)
+	if p.scheduled {
+		p.mu.Unlock()
+		return nil
+	}
+	p.scheduled = true
+	p.mu.Unlock()
+
+	return p.schedule()
+}
+
+// 投递失败或任务被worker丢弃时清空mailbox，请求立即收到错误，不用等到超时
+func (p *process) schedule() error {
+	err := p.system.worker.PostTask(natsrpc.Task{
+		Key:    p.key,
+		Source: "actor",
+		F:      p.run,
+		OnDrop: func() {
+			p.fail(ErrMailboxDropped)
+		},
+	})
+	if err != nil {
+		p.fail(err)
+	}
+	return err
+}
+
+func (p *process) fail(err error) {
+	logger.DefaultLogger.Errorx("actor %s schedule error: %s", nil, p.pid, err.Error())
+	p.mu.Lock()
+	mailbox := p.mailbox
+	p.mailbox = nil
+	p.scheduled = false
+	p.mu.Unlock()
+	for _, env := range mailbox {
+		if env.respond != nil {
+			env.respond(nil, err)
+		}
+	}
+}
+
+func (p *process) run() {
+	for i := 0; i < p.props.Throughput; i++ {
+		p.mu.Lock()
+		if len(p.mailbox) == 0 || p.stopped {
+			p.scheduled = false
+			p.mu.Unlock()
+			return
+		}
+		env := p.mailbox[0]
+		p.mailbox[0] = envelope{}
+		p.mailbox = p.mailbox[1:]
+		p.mu.Unlock()
+
+		p.invoke(env)
+	}
+
+	// 处理完Throughput条消息后让出worker
+	p.mu.Lock()
+	if len(p.mailbox) == 0 || p.stopped {
+		p.scheduled = false
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.schedule()
+}
+
+func (p *process) invoke(env envelope) {
+	// Context和panic恢复共用，保证只应答一次
+	if env.respond != nil {
+		env.respond = respondOnce(env.respond)
+	}
+	switch {
+	case env.stop:
+		p.stop()
+	case env.fn != nil:
+		p.protected(env, env.fn)
+	default:
+		ctx := &Context{
+			system:  p.system,
+			self:    p.pid,
+			sender:  env.sender,
+			msg:     env.msg,
+			respond: env.respond,
+		}
+		p.protected(env, func() {
+			p.actor.Receive(ctx)
+		})
+	}
+}
+
+func (p *process) protected(env envelope, f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.DefaultLogger.Errorx("actor %s panic: %v", nil, p.pid, err)
+			debug.PrintStack()
+			if env.respond != nil {
+				env.respond(nil, fmt.Errorf("%w: %v", ErrActorPanic, err))
+			}
+			p.failed()
+		}
+	}()
+	f()
+}
+
+func respondOnce(f func(proto.Message, error)) func(proto.Message, error) {
+	var once sync.Once
+	return func(msg proto.Message, err error) {
+		once.Do(func() {
+			f(msg, err)
+		})
+	}
+}
+
+// panic后按照Props的策略重启，超过次数时停止
+func (p *process) failed() {
+	now := time.Now()
+	restarts := p.restarts[:0]
+	for _, v := range p.restarts {
+		if now.Sub(v) < p.props.RestartWindow {
+			restarts = append(restarts, v)
+		}
+	}
+	p.restarts = restarts
+
+	if p.props.MaxRestarts < 0 || len(p.restarts) >= p.props.MaxRestarts {
+		logger.DefaultLogger.Errorx("actor %s restart too many times, stop", nil, p.pid)
+		p.stop()
+		return
+	}
+	p.restarts = append(p.restarts, now)
+
+	p.onStopped()
+	p.actor = p.props.Producer()
+	p.onStarted()
+}
+
+func (p *process) onStarted() {
+	if v, ok := p.actor.(Starter); ok {
+		p.callHook(func(ctx *Context) { v.Started(ctx) })
+	}
+}
+
+func (p *process) onStopped() {
+	if v, ok := p.actor.(Stopper); ok {
+		p.callHook(func(ctx *Context) { v.Stopped(ctx) })
+	}
+}
+
+// 生命周期回调中的panic不再触发重启
+func (p *process) callHook(f func(ctx *Context)) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.DefaultLogger.Errorx("actor %s hook panic: %v", nil, p.pid, err)
+			debug.PrintStack()
+		}
+	}()
+	f(&Context{system: p.system, self: p.pid})
+}
+
+func (p *process) stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	pending := p.mailbox
+	p.mailbox = nil
+	p.mu.Unlock()
+
+	p.system.remove(p)
+	p.onStopped()
+	for _, env := range pending {
+		if env.respond != nil {
+			env.respond(nil, ErrActorStopped)
+		}
+	}
+}
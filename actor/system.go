@@ -0,0 +1,291 @@
+package actor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/engine"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// actor系统，本服的actor通过worker调度，其他服务器的actor通过rpc转发
+// 使用ShardedWorker时不同actor可以在不同分片并行执行
+type System struct {
+	serverID int32
+	worker   natsrpc.Worker
+	rpc      *engine.RPC
+
+	mu        sync.RWMutex
+	processes map[string]*process
+}
+
+// rpc为nil时只能使用本地actor，需要在rpc.Run之前调用
+func NewSystem(worker natsrpc.Worker, rpc *engine.RPC) *System {
+	p := &System{
+		worker:    worker,
+		rpc:       rpc,
+		processes: make(map[string]*process),
+	}
+	if rpc != nil {
+		p.serverID = rpc.ID()
+		rpc.RegisterServerMsgHandler(func(s engine.Server, msg *rpcmsg.ActorMsg) {
+			p.handleRemote(msg, nil)
+		})
+		rpc.RegisterRequestMsgHandler(func(s engine.RequestServer, msg *rpcmsg.ActorMsg) {
+			p.handleRemote(msg, func(resp proto.Message, err error) {
+				s.Answer(makeActorResp(resp, err))
+			})
+		})
+	}
+	return p
+}
+
+func (p *System) ServerID() int32 {
+	return p.serverID
+}
+
+// Producer在worker中执行，id重复时不会调用
+func (p *System) Spawn(id string, props Props) (*PID, error) {
+	pid := NewPID(p.serverID, id)
+	proc := newProcess(p, pid, props.withDefault())
+
+	p.mu.Lock()
+	if _, ok := p.processes[id]; ok {
+		p.mu.Unlock()
+		return nil, ErrActorExists
+	}
+	p.processes[id] = proc
+	p.mu.Unlock()
+
+	proc.tell(envelope{fn: proc.start})
+	return pid, nil
+}
+
+func (p *System) Send(pid *PID, msg proto.Message) error {
+	return p.send(pid, nil, msg)
+}
+
+// 阻塞等待应答，不能在worker协程中调用，actor中使用Context.Request
+// timeout为0时使用engine.CALL_TIMEOUT
+func (p *System) Request(pid *PID, msg proto.Message, timeout time.Duration) (proto.Message, error) {
+	type result struct {
+		resp proto.Message
+		err  error
+	}
+	ret := make(chan result, 1)
+	p.requestAsync(pid, nil, msg, timeout, func(resp proto.Message, err error) {
+		ret <- result{resp: resp, err: err}
+	})
+	r := <-ret
+	return r.resp, r.err
+}
+
+// 处理完已经在mailbox中的消息后停止
+func (p *System) Stop(pid *PID) error {
+	if p.isLocal(pid) {
+		proc, ok := p.get(pid.ID)
+		if !ok {
+			return ErrActorNotFound
+		}
+		return proc.tell(envelope{stop: true})
+	}
+	if p.rpc == nil {
+		return ErrNoRPC
+	}
+	p.rpc.GetServerById(pid.ServerID).Notify(&rpcmsg.ActorMsg{Target: pid.ID, Stop: true})
+	return nil
+}
+
+func (p *System) Exists(id string) bool {
+	_, ok := p.get(id)
+	return ok
+}
+
+func (p *System) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.processes)
+}
+
+func (p *System) isLocal(pid *PID) bool {
+	return pid.ServerID == p.serverID
+}
+
+func (p *System) get(id string) (*process, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	v, ok := p.processes[id]
+	return v, ok
+}
+
+func (p *System) remove(proc *process) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.processes[proc.pid.ID]; ok && v == proc {
+		delete(p.processes, proc.pid.ID)
+	}
+}
+
+// 在actor的mailbox中执行f
+func (p *System) post(pid *PID, f func()) error {
+	proc, ok := p.get(pid.ID)
+	if !ok {
+		return ErrActorNotFound
+	}
+	return proc.tell(envelope{fn: f})
+}
+
+func (p *System) send(pid *PID, sender *PID, msg proto.Message) error {
+	if p.isLocal(pid) {
+		proc, ok := p.get(pid.ID)
+		if !ok {
+			return ErrActorNotFound
+		}
+		return proc.tell(envelope{sender: sender, msg: msg})
+	}
+
+	actorMsg, err := p.makeActorMsg(pid, sender, msg)
+	if err != nil {
+		return err
+	}
+	p.rpc.GetServerById(pid.ServerID).Notify(actorMsg)
+	return nil
+}
+
+// cb可能在任意协程中调用，只会调用一次，timeout为0时使用engine.CALL_TIMEOUT
+func (p *System) requestAsync(pid *PID, sender *PID, msg proto.Message, timeout time.Duration, cb func(proto.Message, error)) {
+	if timeout <= 0 {
+		timeout = engine.CALL_TIMEOUT
+	}
+	var once sync.Once
+	finish := func(resp proto.Message, err error) {
+		once.Do(func() {
+			cb(resp, err)
+		})
+	}
+	timer := time.AfterFunc(timeout, func() {
+		finish(nil, ErrTimeOut)
+	})
+	done := func(resp proto.Message, err error) {
+		timer.Stop()
+		finish(resp, err)
+	}
+
+	if p.isLocal(pid) {
+		proc, ok := p.get(pid.ID)
+		if !ok {
+			done(nil, ErrActorNotFound)
+			return
+		}
+		if err := proc.tell(envelope{sender: sender, msg: msg, respond: done}); err != nil {
+			done(nil, err)
+		}
+		return
+	}
+
+	actorMsg, err := p.makeActorMsg(pid, sender, msg)
+	if err != nil {
+		done(nil, err)
+		return
+	}
+	err = p.rpc.GetServerById(pid.ServerID).Request(actorMsg, func(resp *rpcmsg.ActorResp, err error) {
+		if err != nil {
+			done(nil, err)
+			return
+		}
+		done(parseActorResp(resp))
+	})
+	if err != nil {
+		done(nil, err)
+	}
+}
+
+func (p *System) makeActorMsg(pid *PID, sender *PID, msg proto.Message) (*rpcmsg.ActorMsg, error) {
+	if p.rpc == nil {
+		return nil, ErrNoRPC
+	}
+	body, err := anypb.New(msg)
+	if err != nil {
+		return nil, err
+	}
+	actorMsg := &rpcmsg.ActorMsg{
+		Target: pid.ID,
+		Body:   body,
+	}
+	if sender != nil {
+		actorMsg.Senderserver = sender.ServerID
+		actorMsg.Senderid = sender.ID
+	}
+	return actorMsg, nil
+}
+
+func (p *System) handleRemote(actorMsg *rpcmsg.ActorMsg, respond func(proto.Message, error)) {
+	reply := func(resp proto.Message, err error) {
+		if respond != nil {
+			respond(resp, err)
+		}
+	}
+
+	if actorMsg.Stop {
+		reply(nil, p.Stop(NewPID(p.serverID, actorMsg.Target)))
+		return
+	}
+
+	msg, err := actorMsg.Body.UnmarshalNew()
+	if err != nil {
+		logger.DefaultLogger.Errorx("actor %s unmarshal error: %s", nil, actorMsg.Target, err.Error())
+		reply(nil, err)
+		return
+	}
+
+	var sender *PID
+	if actorMsg.Senderid != "" {
+		sender = NewPID(actorMsg.Senderserver, actorMsg.Senderid)
+	}
+
+	proc, ok := p.get(actorMsg.Target)
+	if !ok {
+		reply(nil, ErrActorNotFound)
+		return
+	}
+	env := envelope{sender: sender, msg: msg}
+	if respond != nil {
+		// 投递失败时mailbox和这里都会应答，只应答一次
+		env.respond = respondOnce(respond)
+	}
+	if err := proc.tell(env); err != nil && env.respond != nil {
+		env.respond(nil, err)
+	}
+}
+
+func makeActorResp(msg proto.Message, err error) *rpcmsg.ActorResp {
+	resp := &rpcmsg.ActorResp{}
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	if msg != nil {
+		body, err := anypb.New(msg)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		resp.Body = body
+	}
+	return resp
+}
+
+func parseActorResp(resp *rpcmsg.ActorResp) (proto.Message, error) {
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Body == nil {
+		return nil, nil
+	}
+	return resp.Body.UnmarshalNew()
+}
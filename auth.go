@@ -0,0 +1,100 @@
+package natsrpc
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// 认证需要多条消息时返回，继续等待下一条消息
+	ErrAuthContinue = errors.New("auth: continue")
+	ErrAuthTimeout  = errors.New("auth: timeout")
+)
+
+const DefaultAuthTimeout = 10 * time.Second
+
+// 返回nil时认证通过，返回其他错误时拒绝连接，错误信息作为原因发送给客户端
+// 在连接的读协程中执行，可以阻塞调用engine.Server.Call，访问worker中的数据需要Post
+type AuthHandler func(s Session, msg proto.Message) error
+
+// 设置后连接建立时先进行认证，通过后才调用onNew并路由消息，需要在Run之前调用
+// timeout为从连接建立到认证通过的最长时间，为0时使用DefaultAuthTimeout
+func (p *Mgr) SetAuth(timeout time.Duration, handler AuthHandler) {
+	if timeout <= 0 {
+		timeout = DefaultAuthTimeout
+	}
+	p.auth = handler
+	p.authTimeout = timeout
+}
+
+// 注册认证阶段使用的消息，这些消息只交给AuthHandler，认证通过后收到时忽略
+func (p *Mgr) RegisterAuthMsg(msgs ...proto.Message) {
+	for _, msg := range msgs {
+		p.processor.RegisterSessionMsgHandler(msg, nil)
+	}
+}
+
+// 读取消息交给AuthHandler直到认证通过，失败时通知客户端并断开
+// 与ReadLoop相同检查消息大小和频率，AuthHandler在读协程中同步执行，不会有排队的任务
+func (p *Client) authenticate() bool {
+	timer := time.AfterFunc(p.mgr.authTimeout, func() {
+		p.kick(rpcmsg.Kick_AuthTimeout, ErrAuthTimeout.Error())
+	})
+	defer timer.Stop()
+
+	for {
+		data, err := p.conn.ReadMsg()
+		if err != nil {
+			return false
+		}
+		if p.limiter != nil {
+			if reason := p.limiter.checkFrame(len(data)); reason != "" {
+				if !p.onLimit(0, reason) {
+					return false
+				}
+				continue
+			}
+		}
+		msg, err := p.mgr.processor.Unmarshal(data)
+		if err != nil {
+			logger.DefaultLogger.Errorx("auth unmarshal message error: %v", nil, err)
+			p.kick(rpcmsg.Kick_AuthFailed, err.Error())
+			return false
+		}
+		p.touch()
+		if p.handleHeartbeat(msg) {
+			continue
+		}
+		if p.limiter != nil {
+			msgID, _ := ProtoHash(msg)
+			if reason := p.limiter.checkRate(p.mgr, msgID); reason != "" {
+				if !p.onLimit(msgID, reason) {
+					return false
+				}
+				continue
+			}
+		}
+		err = p.mgr.auth(p, msg)
+		if err == ErrAuthContinue {
+			continue
+		}
+		if err != nil {
+			logger.DefaultLogger.Errorx("auth %v rejected: %v", nil, reflect.TypeOf(msg), err)
+			p.kick(rpcmsg.Kick_AuthFailed, err.Error())
+			return false
+		}
+		// 已经超时，连接已断开
+		return timer.Stop()
+	}
+}
+
+// 发送原因后断开，队列中的消息发送完再关闭底层连接
+func (p *Client) kick(code rpcmsg.Kick_Code, reason string) {
+	p.SendMsg(&rpcmsg.Kick{Code: code, Reason: reason})
+	p.Close()
+}
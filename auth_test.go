@@ -0,0 +1,108 @@
+package natsrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMgrAuth(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	opened := make(chan int32, 4)
+	mgr.RegisterEvent(func(s Session) { opened <- s.ID() }, func(s Session) {})
+	mgr.RegisterAuthMsg(&wrapperspb.Int32Value{})
+	mgr.SetAuth(200*time.Millisecond, func(s Session, msg proto.Message) error {
+		v, ok := msg.(*wrapperspb.Int32Value)
+		if !ok {
+			return errors.New("not login")
+		}
+		switch v.Value {
+		case 0:
+			s.SendMsg(wrapperspb.String("challenge"))
+			return ErrAuthContinue
+		case 1:
+			return nil
+		}
+		return errors.New("bad token")
+	})
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.StringValue) {
+		s.SendMsg(msg)
+	})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&rpcmsg.Kick{}, nil)
+	client.RegisterSessionMsgHandler(&wrapperspb.StringValue{}, nil)
+	addr := mgr.TCPListenAddrs()[0].String()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		return conn
+	}
+	expectKick := func(conn net.Conn, code rpcmsg.Kick_Code) {
+		msg, err := readTCPMsg(conn, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kick, ok := msg.(*rpcmsg.Kick)
+		if !ok || kick.Code != code {
+			t.Fatalf("kick not equal: %v", msg)
+		}
+		if _, err := readTCPMsg(conn, client); err == nil {
+			t.Fatal("conn not closed")
+		}
+	}
+
+	// 两步认证后正常路由
+	conn := dial()
+	writeTCPMsg(conn, client, wrapperspb.Int32(0))
+	if msg, err := readTCPMsg(conn, client); err != nil || msg.(*wrapperspb.StringValue).Value != "challenge" {
+		t.Fatalf("challenge not equal: %v %v", msg, err)
+	}
+	writeTCPMsg(conn, client, wrapperspb.Int32(1))
+	writeTCPMsg(conn, client, wrapperspb.String("hello"))
+	if msg, err := readTCPMsg(conn, client); err != nil || msg.(*wrapperspb.StringValue).Value != "hello" {
+		t.Fatalf("echo not equal: %v %v", msg, err)
+	}
+	id := <-opened
+	if _, ok := mgr.GetSession(id); !ok {
+		t.Errorf("session %d not found", id)
+	}
+	conn.Close()
+
+	// 认证前的业务消息交给AuthHandler
+	conn = dial()
+	writeTCPMsg(conn, client, wrapperspb.String("hello"))
+	expectKick(conn, rpcmsg.Kick_AuthFailed)
+	conn.Close()
+
+	conn = dial()
+	writeTCPMsg(conn, client, wrapperspb.Int32(2))
+	expectKick(conn, rpcmsg.Kick_AuthFailed)
+	conn.Close()
+
+	conn = dial()
+	expectKick(conn, rpcmsg.Kick_AuthTimeout)
+	conn.Close()
+
+	select {
+	case id := <-opened:
+		t.Errorf("unauthenticated session %d opened", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,117 @@
+package natsrpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrBroadcastFull = errors.New("broadcast: queue full")
+
+const (
+	// 每秒最多写入的session数
+	DefaultBroadcastRate = 20000
+	broadcastQueue       = 64
+	broadcastTick        = 10 * time.Millisecond
+)
+
+type broadcastJob struct {
+	data   []byte
+	filter map[string]string
+}
+
+// 广播每秒最多写入的session数，小于等于0时不限制
+func (p *Mgr) SetBroadcastRate(n int) {
+	p.broadcastRate = n
+}
+
+// 发送给所有session，filter不为空时只发送给绑定属性全部相同的session(Session.Bind)
+// 在单独的协程中按速率分批写入，多个广播按顺序执行
+func (p *Mgr) Broadcast(msg proto.Message, filter map[string]string) error {
+	msgID, _ := ProtoHash(msg)
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return p.BroadcastRaw(msgID, data, filter)
+}
+
+// 队列满时丢弃并返回ErrBroadcastFull，丢弃的次数通过BroadcastDropped获取
+func (p *Mgr) BroadcastRaw(msgID uint32, data []byte, filter map[string]string) error {
+	job := broadcastJob{data: p.processor.Encode(msgID, data), filter: filter}
+	select {
+	case p.broadcasts <- job:
+		return nil
+	default:
+		n := atomic.AddInt64(&p.broadcastDrops, 1)
+		logger.DefaultLogger.Warn("broadcast queue full", zap.Uint32("msgID", msgID), zap.Int64("dropped", n))
+		return ErrBroadcastFull
+	}
+}
+
+// 队列满时丢弃的广播数
+func (p *Mgr) BroadcastDropped() int64 {
+	return atomic.LoadInt64(&p.broadcastDrops)
+}
+
+func (p *Mgr) broadcastLoop(done chan struct{}) {
+	for {
+		select {
+		case job := <-p.broadcasts:
+			if !p.broadcast(job, done) {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// 关闭时返回false
+func (p *Mgr) broadcast(job broadcastJob, done chan struct{}) bool {
+	p.sesMutex.Lock()
+	clients := make([]*Client, 0, len(p.sesID2Client))
+	for _, c := range p.sesID2Client {
+		clients = append(clients, c)
+	}
+	p.sesMutex.Unlock()
+
+	batch := p.broadcastRate * int(broadcastTick) / int(time.Second)
+	if p.broadcastRate > 0 && batch <= 0 {
+		batch = 1
+	}
+	sent := 0
+	for _, c := range clients {
+		if !c.matchBound(job.filter) {
+			continue
+		}
+		c.writeRaw(job.data)
+		sent++
+		if batch > 0 && sent%batch == 0 {
+			select {
+			case <-time.After(broadcastTick):
+			case <-done:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *Client) matchBound(filter map[string]string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	p.attrMu.RLock()
+	defer p.attrMu.RUnlock()
+	for k, v := range filter {
+		if p.bound[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package natsrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMgrBroadcast(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	// 每10ms写入一个session
+	mgr.SetBroadcastRate(100)
+	mgr.RegisterAuthMsg(&wrapperspb.StringValue{})
+	mgr.SetAuth(time.Second, func(s Session, msg proto.Message) error {
+		s.Bind("vip", msg.(*wrapperspb.StringValue).Value)
+		return nil
+	})
+	opened := make(chan int32, 4)
+	mgr.RegisterEvent(func(s Session) { opened <- s.ID() }, func(s Session) {})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&wrapperspb.Int32Value{}, nil)
+	var conns []net.Conn
+	for _, vip := range []string{"1", "1", "0", "1"} {
+		conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		writeTCPMsg(conn, client, wrapperspb.String(vip))
+		<-opened
+		conns = append(conns, conn)
+	}
+
+	start := time.Now()
+	mgr.Broadcast(wrapperspb.Int32(1), map[string]string{"vip": "1"})
+	mgr.Broadcast(wrapperspb.Int32(2), nil)
+	for i, conn := range conns {
+		want := []int32{1, 2}
+		if i == 2 {
+			want = want[1:]
+		}
+		for _, v := range want {
+			msg, err := readTCPMsg(conn, client)
+			if err != nil || msg.(*wrapperspb.Int32Value).Value != v {
+				t.Fatalf("conn %d broadcast not equal: %v %v", i, msg, err)
+			}
+		}
+	}
+	// 共写入7次，前6次每次写入后等待
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("broadcast not throttled: %v", d)
+	}
+}
+
+func TestMgrBroadcastFull(t *testing.T) {
+	mgr := NewMgr("", NewWorker())
+	// 没有Run时不消费队列
+	for i := 0; i < broadcastQueue; i++ {
+		if err := mgr.Broadcast(wrapperspb.Int32(int32(i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mgr.Broadcast(wrapperspb.Int32(0), nil); err != ErrBroadcastFull {
+		t.Errorf("expect ErrBroadcastFull, got %v", err)
+	}
+	if n := mgr.BroadcastDropped(); n != 1 {
+		t.Errorf("dropped not equal: %d", n)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"reflect"
+	"sync"
+	"time"
 )
 
 type Session interface {
@@ -12,51 +14,137 @@ type Session interface {
 	SendRawMsg(msgID uint32, data []byte)
 	ID() int32
 	Close()
+
+	// 本地属性，只在gate中使用，按类型读取使用GetAttr
+	Set(key string, value interface{})
+	Get(key string) (interface{}, bool)
+	// 绑定的属性随RouteSession发送到后端服务器，value为空时删除
+	Bind(key, value string)
+	Bound() map[string]string
+	// 绑定用户后可以通过Mgr.GetSessionByUser查找，同一个用户再次绑定时查找到新的session
+	BindUser(userID int64)
+	UserID() int64
+
+	// 最后一次收到消息的时间，开启心跳后超过Timeout时断开
+	LastActive() time.Time
+	RTT() time.Duration
 }
 
 type Client struct {
-	conn Conn
-	mgr  *Mgr
+	lastActive int64 //unix ns，原子操作
+	rtt        int64
+
+	conn    Conn
+	pinger  pingConn        //为nil时不支持协议层心跳
+	limiter *sessionLimiter //为nil时不限制
+	mgr     *Mgr
+	network string //ws、tcp，用于worker的任务来源统计
+	// 认证通过后加入sesID2Client，只在读协程中访问
+	accepted bool
+
+	attrMu sync.RWMutex
+	values map[string]interface{}
+	bound  map[string]string
+	userID int64
+
+	groups map[string]struct{} //mgr.sesMutex保护
 }
 
 func NewClient(conn Conn, mgr *Mgr) *Client {
 	p := &Client{
-		conn: conn,
-		mgr:  mgr,
+		conn:    conn,
+		mgr:     mgr,
+		network: "ws",
 	}
+	p.touch()
 	return p
 }
 
 func (p *Client) OnNew() {
-	p.mgr.Post(func() {
-		p.mgr.sesID2Client[p.conn.ID()] = p
+	if p.mgr.auth != nil && !p.authenticate() {
+		return
+	}
+	p.accepted = true
+	p.mgr.postSession(p.ID(), p.network+":open", PriorityNormal, func() {
+		p.mgr.addClient(p)
 		p.mgr.onNew(p)
 	})
 	p.ReadLoop()
 }
 
 func (p *Client) ReadLoop() {
+	limiter := p.limiter
 	for {
 		data, err := p.conn.ReadMsg()
 		if err != nil {
 			logger.DefaultLogger.Errorx("read message: %s", nil, err.Error())
 			break
 		}
+		if limiter != nil {
+			if reason := limiter.checkFrame(len(data)); reason != "" {
+				if !p.onLimit(0, reason) {
+					break
+				}
+				continue
+			}
+		}
 
 		msg, err := p.mgr.processor.Unmarshal(data)
 		if err != nil {
 			logger.DefaultLogger.Errorx("unmarshal message error: %v", nil, err)
 			break
 		}
-		p.mgr.Post(func() {
-			err = p.mgr.processor.Handle(msg, p)
-		})
+		p.touch()
+		if p.handleHeartbeat(msg) {
+			continue
+		}
+
+		msgID, _ := ProtoHash(msg)
+		t := Task{
+			Key:      uint32(p.ID()),
+			Source:   p.network + ":" + string(proto.MessageName(msg)),
+			Priority: p.mgr.processor.GetPriority(msg),
+			F: func() {
+				p.mgr.processor.Handle(msg, p)
+			},
+		}
+		if limiter != nil {
+			reason := limiter.checkRate(p.mgr, msgID)
+			if reason == "" && !limiter.acquire() {
+				reason = "too many pending messages"
+			}
+			if reason != "" {
+				if !p.onLimit(msgID, reason) {
+					break
+				}
+				continue
+			}
+			t.F = func() {
+				defer limiter.done()
+				p.mgr.processor.Handle(msg, p)
+			}
+			t.OnDrop = limiter.done
+		}
+		// worker满时按worker的策略阻塞或丢弃，阻塞时不再读取，由底层连接反压
+		err = p.mgr.worker.PostTask(t)
+		if err != nil && limiter != nil {
+			limiter.done()
+		}
+		if err == ErrWorkerClosed {
+			break
+		}
+		if err != nil {
+			logger.DefaultLogger.Errorx("post message %v error: %v", nil, reflect.TypeOf(msg), err)
+		}
 	}
 }
 
 func (p *Client) OnClose() {
-	p.mgr.Post(func() {
-		delete(p.mgr.sesID2Client, p.conn.ID())
+	if !p.accepted {
+		return
+	}
+	p.mgr.postSession(p.ID(), p.network+":close", PriorityNormal, func() {
+		p.mgr.removeClient(p)
 		p.mgr.onClose(p)
 	})
 }
@@ -74,8 +162,12 @@ func (p *Client) SendMsg(msg proto.Message) {
 }
 
 func (p *Client) SendRawMsg(msgID uint32, data []byte) {
-	newData := p.mgr.processor.Encode(msgID, data)
-	err := p.conn.WriteMsg(newData)
+	p.writeRaw(p.mgr.processor.Encode(msgID, data))
+}
+
+// data为已经编码好msgID的消息
+func (p *Client) writeRaw(data []byte) {
+	err := p.conn.WriteMsg(data)
 	if err != nil {
 		logger.DefaultLogger.Errorx("write message error: %v", nil, err)
 	}
@@ -88,3 +180,72 @@ func (p *Client) ID() int32 {
 func (p *Client) Close() {
 	p.conn.Close()
 }
+
+func (p *Client) Set(key string, value interface{}) {
+	p.attrMu.Lock()
+	defer p.attrMu.Unlock()
+	if p.values == nil {
+		p.values = make(map[string]interface{})
+	}
+	p.values[key] = value
+}
+
+func (p *Client) Get(key string) (interface{}, bool) {
+	p.attrMu.RLock()
+	defer p.attrMu.RUnlock()
+	v, ok := p.values[key]
+	return v, ok
+}
+
+func (p *Client) Bind(key, value string) {
+	p.attrMu.Lock()
+	defer p.attrMu.Unlock()
+	if value == "" {
+		delete(p.bound, key)
+		return
+	}
+	if p.bound == nil {
+		p.bound = make(map[string]string)
+	}
+	p.bound[key] = value
+}
+
+// 返回副本
+func (p *Client) Bound() map[string]string {
+	p.attrMu.RLock()
+	defer p.attrMu.RUnlock()
+	if len(p.bound) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(p.bound))
+	for k, v := range p.bound {
+		ret[k] = v
+	}
+	return ret
+}
+
+// 认证阶段绑定时在认证通过后才能查找到
+func (p *Client) BindUser(userID int64) {
+	p.attrMu.Lock()
+	old := p.userID
+	p.userID = userID
+	p.attrMu.Unlock()
+	p.mgr.bindUser(p, old, userID)
+}
+
+func (p *Client) UserID() int64 {
+	p.attrMu.RLock()
+	defer p.attrMu.RUnlock()
+	return p.userID
+}
+
+// 按类型读取本地属性，不存在或类型不一致时返回false
+func GetAttr[T any](s Session, key string) (T, bool) {
+	v, ok := s.Get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	ret, ok := v.(T)
+	return ret, ok
+}
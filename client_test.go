@@ -0,0 +1,138 @@
+package natsrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSessionBindUser(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	closed := make(chan int32, 2)
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) { closed <- s.ID() })
+	mgr.RegisterAuthMsg(&wrapperspb.Int64Value{})
+	mgr.SetAuth(time.Second, func(s Session, msg proto.Message) error {
+		uid := msg.(*wrapperspb.Int64Value).Value
+		s.BindUser(uid)
+		s.Bind("role", "admin")
+		s.Set("level", 10)
+		// 认证通过前不能查找到
+		if found, ok := mgr.GetSessionByUser(uid); ok && found.ID() == s.ID() {
+			t.Error("session found before auth")
+		}
+		return nil
+	})
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.StringValue) {
+		level, ok := GetAttr[int](s, "level")
+		if !ok || level != 10 {
+			t.Errorf("level not equal: %v", level)
+		}
+		if _, ok := GetAttr[string](s, "level"); ok {
+			t.Error("attr type not checked")
+		}
+		if s.Bound()["role"] != "admin" {
+			t.Errorf("bound not equal: %v", s.Bound())
+		}
+		s.SendMsg(wrapperspb.Int64(s.UserID()))
+	})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&wrapperspb.Int64Value{}, nil)
+	login := func(uid int64) net.Conn {
+		conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		writeTCPMsg(conn, client, wrapperspb.Int64(uid))
+		writeTCPMsg(conn, client, wrapperspb.String("hello"))
+		msg, err := readTCPMsg(conn, client)
+		if err != nil || msg.(*wrapperspb.Int64Value).Value != uid {
+			t.Fatalf("uid not equal: %v %v", msg, err)
+		}
+		return conn
+	}
+
+	conn1 := login(100)
+	s1, ok := mgr.GetSessionByUser(100)
+	if !ok {
+		t.Fatal("session not found")
+	}
+	// 同一用户再次登录，查找到新的session，旧session断开时不影响
+	conn2 := login(100)
+	s2, _ := mgr.GetSessionByUser(100)
+	if s2.ID() == s1.ID() {
+		t.Errorf("session not replaced: %d", s2.ID())
+	}
+	conn1.Close()
+	<-closed
+	if s, ok := mgr.GetSessionByUser(100); !ok || s.ID() != s2.ID() {
+		t.Error("session removed by old session")
+	}
+
+	s2.BindUser(200)
+	if _, ok := mgr.GetSessionByUser(100); ok {
+		t.Error("old user not unbound")
+	}
+	conn2.Close()
+	<-closed
+	if _, ok := mgr.GetSessionByUser(200); ok {
+		t.Error("user not removed after close")
+	}
+}
+
+func TestMgrCloseSession(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	opened := make(chan int32, 1)
+	closed := make(chan int32, 1)
+	mgr.RegisterEvent(func(s Session) { opened <- s.ID() }, func(s Session) { closed <- s.ID() })
+	mgr.Run()
+	defer mgr.Close()
+
+	if mgr.CloseSession(1000, "") {
+		t.Error("unknown session closed")
+	}
+	conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	id := <-opened
+	if !mgr.CloseSession(id, "duplicate login") {
+		t.Fatal("session not found")
+	}
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&rpcmsg.Kick{}, nil)
+	msg, err := readTCPMsg(conn, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kick := msg.(*rpcmsg.Kick); kick.Code != rpcmsg.Kick_Kicked || kick.Reason != "duplicate login" {
+		t.Errorf("kick not equal: %v", kick)
+	}
+	if _, err := readTCPMsg(conn, client); err == nil {
+		t.Error("conn not closed")
+	}
+	if v := <-closed; v != id {
+		t.Errorf("closed session not equal: %d", v)
+	}
+}
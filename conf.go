@@ -21,6 +21,17 @@ type Conn interface {
 
 type Config struct {
 	Nats string `json:"nats"`
+	// worker分片数，大于1时使用ShardedWorker，session消息按session分到不同协程
+	// 此时Post、定时器、帧循环与session handler并行执行，共享的数据需要加锁
+	Shards int          `json:"shards"`
+	Worker WorkerConfig `json:"worker"`
+}
+
+func (p *Config) NewWorker() Worker {
+	if p.Shards > 1 {
+		return NewShardedWorkerWithConfig(p.Shards, p.Worker)
+	}
+	return NewWorkerWithConfig(p.Worker)
 }
 
 func ReadConfig(filename string) (*Config, error) {
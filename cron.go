@@ -0,0 +1,354 @@
+package natsrpc
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+// 错过触发时间后的处理方式，如任务暂停、进程卡顿、机器休眠后恢复
+type MissedPolicy int
+
+const (
+	MissedRunOnce MissedPolicy = iota //错过多次时只补执行一次，默认
+	MissedRunAll                      //每个错过的时间点都补执行，最多CronMaxCatchUp次
+	MissedSkip                        //超过CronGrace的都不再执行，等待下一个时间点
+)
+
+const (
+	CronGrace      = time.Second
+	CronMaxCatchUp = 64
+)
+
+// cron调度器，一个协程按照最近的触发时间等待，到期后投递到worker中执行
+type Cron struct {
+	worker Worker
+	loc    *time.Location
+
+	mu   sync.Mutex
+	jobs map[*CronJob]struct{}
+
+	wake   chan struct{}
+	done   chan struct{}
+	closed int32
+	closer io.Closer
+}
+
+type CronJob struct {
+	cron   *Cron
+	spec   string
+	expr   *CronExpr
+	policy MissedPolicy
+	pri    Priority
+	f      func()
+
+	// 以下字段由cron.mu保护
+	next  time.Time
+	prev  time.Time
+	state int32
+	gen   uint64 //取消、暂停时加1，已投递到worker的旧回调不会再执行
+}
+
+type cronCloser struct {
+	cron *Cron
+}
+
+func (p *cronCloser) Close() error {
+	p.cron.Close()
+	return nil
+}
+
+// loc为nil时使用time.Local，表达式中指定了时区时以表达式为准
+func NewCron(worker Worker, loc *time.Location) *Cron {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &Cron{
+		worker: worker,
+		loc:    loc,
+		jobs:   make(map[*CronJob]struct{}),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (p *Cron) Run() {
+	if t, ok := p.worker.(timerTracker); ok {
+		p.closer = &cronCloser{cron: p}
+		if !t.track(p.closer) {
+			p.Close()
+			return
+		}
+	}
+	go func() {
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+		for {
+			p.process(time.Now())
+
+			wait := time.Hour
+			if next, ok := p.earliest(); ok {
+				wait = time.Until(next)
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+			select {
+			case <-timer.C:
+			case <-p.wake:
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+func (p *Cron) Close() {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return
+	}
+	close(p.done)
+	if t, ok := p.worker.(timerTracker); ok && p.closer != nil {
+		t.untrack(p.closer)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for job := range p.jobs {
+		job.state = timerCancelled
+		job.gen++
+	}
+	p.jobs = make(map[*CronJob]struct{})
+}
+
+func (p *Cron) Add(spec string, f func()) (*CronJob, error) {
+	return p.AddJob(spec, MissedRunOnce, PriorityNormal, f)
+}
+
+func (p *Cron) AddJob(spec string, policy MissedPolicy, pri Priority, f func()) (*CronJob, error) {
+	expr, err := ParseCronInLocation(spec, p.loc)
+	if err != nil {
+		return nil, err
+	}
+	job := &CronJob{
+		cron:   p,
+		spec:   spec,
+		expr:   expr,
+		policy: policy,
+		pri:    pri,
+		f:      f,
+	}
+
+	p.mu.Lock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		job.state = timerCancelled
+		p.mu.Unlock()
+		return job, nil
+	}
+	job.next = expr.Next(time.Now())
+	p.jobs[job] = struct{}{}
+	p.mu.Unlock()
+
+	p.notify()
+	return job, nil
+}
+
+// 按照下次触发时间排序
+func (p *Cron) Jobs() []*CronJob {
+	p.mu.Lock()
+	ret := make([]*CronJob, 0, len(p.jobs))
+	for job := range p.jobs {
+		ret = append(ret, job)
+	}
+	p.mu.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Next().Before(ret[j].Next())
+	})
+	return ret
+}
+
+func (p *Cron) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.jobs)
+}
+
+func (p *Cron) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Cron) earliest() (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ret time.Time
+	for job := range p.jobs {
+		if job.state != timerPending || job.next.IsZero() {
+			continue
+		}
+		if ret.IsZero() || job.next.Before(ret) {
+			ret = job.next
+		}
+	}
+	return ret, !ret.IsZero()
+}
+
+// 执行now之前到期的任务
+func (p *Cron) process(now time.Time) {
+	type fire struct {
+		job *CronJob
+		at  time.Time
+		gen uint64
+	}
+	var fires []fire
+
+	p.mu.Lock()
+	for job := range p.jobs {
+		if job.state != timerPending || job.next.IsZero() || job.next.After(now) {
+			continue
+		}
+		due := job.next
+		switch job.policy {
+		case MissedRunAll:
+			t := due
+			n := 0
+			for ; !t.IsZero() && !t.After(now) && n < CronMaxCatchUp; n++ {
+				fires = append(fires, fire{job: job, at: t, gen: job.gen})
+				t = job.expr.Next(t)
+			}
+			if !t.IsZero() && !t.After(now) {
+				logger.DefaultLogger.Warn("cron drop missed runs", zap.String("spec", job.spec), zap.Time("from", t))
+			}
+		case MissedSkip:
+			if now.Sub(due) <= CronGrace {
+				fires = append(fires, fire{job: job, at: due, gen: job.gen})
+			}
+		default:
+			fires = append(fires, fire{job: job, at: due, gen: job.gen})
+		}
+		job.next = job.expr.Next(now)
+		if job.next.IsZero() {
+			delete(p.jobs, job)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, v := range fires {
+		p.fire(v.job, v.at, v.gen)
+	}
+}
+
+func (p *Cron) fire(job *CronJob, at time.Time, gen uint64) {
+	err := p.worker.PostTask(Task{
+		Source:   SourceCron,
+		Priority: job.pri,
+		F: func() {
+			if !job.begin(at, gen) {
+				return
+			}
+			job.f()
+		},
+	})
+	if err == ErrWorkerClosed {
+		p.Close()
+	}
+}
+
+func (t *CronJob) begin(at time.Time, gen uint64) bool {
+	p := t.cron
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.gen != gen || t.state != timerPending {
+		return false
+	}
+	t.prev = at
+	return true
+}
+
+func (t *CronJob) Spec() string {
+	return t.spec
+}
+
+// 下次触发时间，暂停、取消或不会再触发时返回零值
+func (t *CronJob) Next() time.Time {
+	p := t.cron
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state != timerPending {
+		return time.Time{}
+	}
+	return t.next
+}
+
+// 之后n次的触发时间
+func (t *CronJob) NextN(n int) []time.Time {
+	next := t.Next()
+	ret := make([]time.Time, 0, n)
+	for i := 0; i < n && !next.IsZero(); i++ {
+		ret = append(ret, next)
+		next = t.expr.Next(next)
+	}
+	return ret
+}
+
+// 最近一次执行对应的触发时间，补执行时为错过的时间点
+func (t *CronJob) Prev() time.Time {
+	p := t.cron
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return t.prev
+}
+
+// 取消后回调不会再开始执行
+func (t *CronJob) Cancel() bool {
+	p := t.cron
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state == timerCancelled {
+		return false
+	}
+	delete(p.jobs, t)
+	t.state = timerCancelled
+	t.gen++
+	return true
+}
+
+// 暂停期间错过的触发时间在Resume时按照MissedPolicy处理
+func (t *CronJob) Pause() bool {
+	p := t.cron
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state != timerPending {
+		return false
+	}
+	t.state = timerPaused
+	t.gen++
+	return true
+}
+
+func (t *CronJob) Resume() bool {
+	p := t.cron
+	p.mu.Lock()
+	if t.state != timerPaused {
+		p.mu.Unlock()
+		return false
+	}
+	t.state = timerPending
+	p.mu.Unlock()
+
+	p.notify()
+	return true
+}
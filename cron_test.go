@@ -0,0 +1,96 @@
+package natsrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		spec   string
+		from   time.Time
+		expect time.Time
+	}{
+		{"5 * * * *", time.Date(2024, 1, 1, 10, 5, 0, 0, shanghai), time.Date(2024, 1, 1, 11, 5, 0, 0, shanghai)},
+		{"0 5 * * *", time.Date(2024, 1, 31, 6, 0, 0, 0, shanghai), time.Date(2024, 2, 1, 5, 0, 0, 0, shanghai)},
+		{"30 */10 * * * *", time.Date(2024, 1, 1, 0, 0, 0, 0, shanghai), time.Date(2024, 1, 1, 0, 0, 30, 0, shanghai)},
+		{"0 0 * * mon", time.Date(2024, 1, 1, 0, 0, 0, 0, shanghai), time.Date(2024, 1, 8, 0, 0, 0, 0, shanghai)},
+		{"0 0 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, shanghai), time.Date(2024, 1, 7, 0, 0, 0, 0, shanghai)},
+		// 日和周都指定时满足其一即可
+		{"0 0 15 * 1", time.Date(2024, 1, 9, 0, 0, 0, 0, shanghai), time.Date(2024, 1, 15, 0, 0, 0, 0, shanghai)},
+		{"0 0 29 feb *", time.Date(2023, 3, 1, 0, 0, 0, 0, shanghai), time.Date(2024, 2, 29, 0, 0, 0, 0, shanghai)},
+		{"0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, shanghai), time.Time{}},
+		{"@daily", time.Date(2024, 1, 1, 12, 0, 0, 0, shanghai), time.Date(2024, 1, 2, 0, 0, 0, 0, shanghai)},
+		// 时区前缀，上海时间5点为UTC前一天21点
+		{"CRON_TZ=Asia/Shanghai 0 5 * * *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 21, 0, 0, 0, time.UTC)},
+		// 夏令时开始当天没有2:30
+		{"30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+	}
+	for _, c := range cases {
+		expr, err := ParseCron(c.spec)
+		if err != nil {
+			t.Errorf("parse %s error: %v", c.spec, err)
+			continue
+		}
+		if next := expr.Next(c.from); !next.Equal(c.expect) {
+			t.Errorf("%s next not equal: %v, expect %v", c.spec, next, c.expect)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "TZ=Bad/Zone * * * * *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("parse %s should fail", spec)
+		}
+	}
+}
+
+func TestCronMissedPolicy(t *testing.T) {
+	w := NewWorker()
+	cron := NewCron(w, time.UTC)
+
+	var ret []string
+	once, _ := cron.AddJob("0 * * * *", MissedRunOnce, PriorityNormal, func() { ret = append(ret, "once") })
+	all, _ := cron.AddJob("0 * * * *", MissedRunAll, PriorityNormal, func() { ret = append(ret, "all") })
+	skip, _ := cron.AddJob("0 * * * *", MissedSkip, PriorityNormal, func() { ret = append(ret, "skip") })
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, job := range []*CronJob{once, all, skip} {
+		job.Pause()
+		cron.mu.Lock()
+		job.next = start
+		cron.mu.Unlock()
+		job.Resume()
+	}
+	if n := once.NextN(3); len(n) != 3 || !n[2].Equal(start.Add(2*time.Hour)) {
+		t.Errorf("next n not equal: %v", n)
+	}
+
+	// 错过了3个整点
+	cron.process(start.Add(2*time.Hour + 30*time.Minute))
+	if !once.Next().Equal(start.Add(3 * time.Hour)) {
+		t.Errorf("next not equal: %v", once.Next())
+	}
+	w.Run()
+	w.Shutdown(context.Background())
+
+	count := map[string]int{}
+	for _, v := range ret {
+		count[v]++
+	}
+	if count["once"] != 1 || count["all"] != 3 || count["skip"] != 0 {
+		t.Errorf("result not equal: %v", count)
+	}
+	if !all.Prev().Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("prev not equal: %v", all.Prev())
+	}
+}
@@ -0,0 +1,267 @@
+package natsrpc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrCronSpec = errors.New("cron: invalid spec")
+
+// cron表达式，支持5段(分 时 日 月 周)和6段(秒 分 时 日 月 周)
+// 每段支持 * ? , - / 以及月份、星期的英文缩写，星期的0和7都表示周日
+// 日和周都不是*时满足其一即可，与标准cron一致
+// 可以用CRON_TZ=Asia/Shanghai或TZ=Asia/Shanghai前缀指定时区
+type CronExpr struct {
+	second, minute, hour, dom, month, dow uint64
+
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSecond = cronField{min: 0, max: 59}
+	cronMinute = cronField{min: 0, max: 59}
+	cronHour   = cronField{min: 0, max: 23}
+	cronDom    = cronField{min: 1, max: 31}
+	cronMonth  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+func ParseCron(spec string) (*CronExpr, error) {
+	return ParseCronInLocation(spec, nil)
+}
+
+// loc为nil时按照传入Next的时间所在时区计算，spec中的时区前缀优先
+func ParseCronInLocation(spec string, loc *time.Location) (*CronExpr, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexByte(spec, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrCronSpec, spec)
+		}
+		name := spec[strings.IndexByte(spec, '=')+1 : i]
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCronSpec, err.Error())
+		}
+		loc = l
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if v, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = v
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q need 5 or 6 fields", ErrCronSpec, spec)
+	}
+
+	p := &CronExpr{loc: loc}
+	var err error
+	if p.second, err = cronSecond.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if p.minute, err = cronMinute.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if p.hour, err = cronHour.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if p.dom, err = cronDom.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if p.month, err = cronMonth.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if p.dow, err = cronDow.parse(fields[5]); err != nil {
+		return nil, err
+	}
+	// 7也是周日
+	if p.dow&(1<<7) != 0 {
+		p.dow = p.dow&^(1<<7) | 1
+	}
+	p.domStar = fields[3] == "*" || fields[3] == "?"
+	p.dowStar = fields[5] == "*" || fields[5] == "?"
+	return p, nil
+}
+
+func MustParseCron(spec string) *CronExpr {
+	p, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		b, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// a、a-b、*、a/n、a-b/n、*/n
+func (f cronField) parsePart(s string) (uint64, error) {
+	rng, step := s, 1
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		n, err := strconv.Atoi(s[i+1:])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%w: step %q", ErrCronSpec, s)
+		}
+		rng, step = s[:i], n
+	}
+
+	var start, end int
+	switch {
+	case rng == "*" || rng == "?":
+		start, end = f.min, f.max
+	case strings.IndexByte(rng, '-') > 0:
+		i := strings.IndexByte(rng, '-')
+		var err error
+		if start, err = f.value(rng[:i]); err != nil {
+			return 0, err
+		}
+		if end, err = f.value(rng[i+1:]); err != nil {
+			return 0, err
+		}
+	default:
+		v, err := f.value(rng)
+		if err != nil {
+			return 0, err
+		}
+		start, end = v, v
+		// 5/15表示从5开始每15
+		if step > 1 {
+			end = f.max
+		}
+	}
+	if start > end {
+		return 0, fmt.Errorf("%w: range %q", ErrCronSpec, s)
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrCronSpec, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%w: %d out of range [%d, %d]", ErrCronSpec, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (p *CronExpr) Location() *time.Location {
+	return p.loc
+}
+
+// 返回t之后的第一个触发时间，没有时返回零值，如2月30日
+func (p *CronExpr) Next(t time.Time) time.Time {
+	loc := p.loc
+	if loc == nil {
+		loc = t.Location()
+	}
+	t = t.In(loc).Truncate(time.Second).Add(time.Second)
+	limit := t.Year() + 5
+
+	// 从大到小依次匹配，某个字段进位时从月份重新检查
+	// 夏令时跳过的时间不会触发，重复的时间可能触发两次
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for !cronHas(p.month, int(t.Month())) {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !p.dayMatch(t) {
+		next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		if !next.After(t) {
+			// 0点被夏令时跳过
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 1, 0, 0, 0, loc)
+		}
+		t = next
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for !cronHas(p.hour, t.Hour()) {
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		if !next.After(t) {
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+2, 0, 0, 0, loc)
+		}
+		t = next
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for !cronHas(p.minute, t.Minute()) {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for !cronHas(p.second, t.Second()) {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+func (p *CronExpr) dayMatch(t time.Time) bool {
+	dom := cronHas(p.dom, t.Day())
+	dow := cronHas(p.dow, int(t.Weekday()))
+	if p.domStar || p.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func cronHas(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
@@ -1,9 +1,11 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -16,17 +18,35 @@ import (
 
 var ErrTimeOut = errors.New("rpc: timeout")
 var ErrNoKnow = errors.New("rpc: unknow")
+var ErrClientClosed = errors.New("rpc: client closed")
 
 const CALL_TIMEOUT = 10 * time.Second
 
+// 并发handler共享的协程池大小
+const CONCURRENT_POOL_SIZE = 64
+
 type Client struct {
-	conn         *nats.Conn
-	serverTopic  string
-	serverID     int32
-	send2Session func(sesID int32, msgID uint32, data []byte) //gate服专用
-	processor    *Processor
-	worker       natsrpc.Worker
-	close        chan struct{}
+	conn            *nats.Conn
+	serverTopic     string
+	serverID        int32
+	send2Session    func(sesID int32, msgID uint32, data []byte)              //gate服专用
+	closeSession    func(sesID int32, msgID uint32, data []byte)              //gate服专用
+	onRebind        func(sesID int32, from int32, to int32)                   //gate服专用
+	onGroup         func(group string, sesID int32, join bool)                //gate服专用
+	send2Group      func(group string, msgID uint32, data []byte)             //gate服专用
+	onBroadcast     func(msgID uint32, data []byte, filter map[string]string) //gate服专用
+	broadcastSub    *nats.Subscription
+	onSessionOpened func(Session)
+	onSessionClosed func(Session)
+	sessionEventSub *nats.Subscription //不为nil时只处理全局的session事件
+	groupMu         sync.Mutex
+	groupSubs       map[string]*nats.Subscription
+	processor       *Processor
+	worker          natsrpc.Worker
+	pool            *natsrpc.Pool //并发handler使用，不经过worker
+	dedup           *dedupCache   //为nil时不去重
+	close           chan struct{} //Close时关闭，通知ReadLoop退出
+	closeOnce       sync.Once
 }
 
 func newClient(serverID int32, worker natsrpc.Worker, natsUrl string) (*Client, error) {
@@ -42,18 +62,25 @@ func newClient(serverID int32, worker natsrpc.Worker, natsUrl string) (*Client,
 	p.serverTopic = fmt.Sprintf("%v", serverID)
 	p.processor = NewProcessor()
 	p.worker = worker
+	p.pool = natsrpc.NewPool(CONCURRENT_POOL_SIZE)
 	p.close = make(chan struct{})
+	p.groupSubs = make(map[string]*nats.Subscription)
 	return p, nil
 }
 
 // 阻塞式
 func (p *Client) Call(serverTopic string, req proto.Message, resp proto.Message) error {
+	return p.CallWithKey(serverTopic, "", req, resp)
+}
+
+// 带幂等key的Call，重试时使用相同的key，对端开启去重后不会重复执行
+func (p *Client) CallWithKey(serverTopic string, idemKey string, req proto.Message, resp proto.Message) error {
 	ret := make(chan error)
 	call := p.processor.RegisterCall(resp, func(err error) {
 		ret <- err
 	})
 
-	data := MakeRequestData(req, call.seqID, p.serverID)
+	data := MakeIdempotentRequestData(req, call.seqID, p.serverID, idemKey)
 	err := p.publish(serverTopic, data)
 	if err != nil {
 		return err
@@ -69,12 +96,14 @@ func (p *Client) Call(serverTopic string, req proto.Message, resp proto.Message)
 		p.processor.GetCallWithDel(call.seqID)
 		return ErrTimeOut
 	}
-
-	return ErrNoKnow
 }
 
 // 不需要注册Response的Request请求 onRecv func(*msg.XXX,error)
 func (p *Client) Request(serverTopic string, msg proto.Message, cb interface{}) error {
+	return p.RequestWithKey(serverTopic, "", msg, cb)
+}
+
+func (p *Client) RequestWithKey(serverTopic string, idemKey string, msg proto.Message, cb interface{}) error {
 	cbType := reflect.TypeOf(cb)
 	if cbType.Kind() != reflect.Func {
 		return errors.New("cb not a func")
@@ -105,7 +134,7 @@ func (p *Client) Request(serverTopic string, msg proto.Message, cb interface{})
 	}
 
 	call := p.processor.RegisterCall(resp, onRecv)
-	data := MakeRequestData(msg, call.seqID, p.serverID)
+	data := MakeIdempotentRequestData(msg, call.seqID, p.serverID, idemKey)
 	err := p.publish(serverTopic, data)
 	if err != nil {
 		return err
@@ -138,12 +167,34 @@ func (p *Client) RouteGate(gateTopic string, sesID int32, msg proto.Message) {
 	p.publish(gateTopic, data)
 }
 
+func (p *Client) RouteGateRaw(gateTopic string, sesID int32, msgID uint32, msgData []byte) {
+	data := MakeServer2SessionRawData(msgID, msgData, sesID, p.serverID)
+	p.publish(gateTopic, data)
+}
+
 func (p *Client) Run() {
+	p.pool.Run()
 	go p.ReadLoop()
 }
 
+// 可以重复调用，没有Run时也不会阻塞
 func (p *Client) Close() (err error) {
-	p.close <- struct{}{}
+	p.closeOnce.Do(func() {
+		if p.broadcastSub != nil {
+			p.broadcastSub.Unsubscribe()
+		}
+		if p.sessionEventSub != nil {
+			p.sessionEventSub.Unsubscribe()
+		}
+		p.groupMu.Lock()
+		for group, sub := range p.groupSubs {
+			sub.Unsubscribe()
+			delete(p.groupSubs, group)
+		}
+		p.groupMu.Unlock()
+		close(p.close)
+		p.pool.Close()
+	})
 	return
 }
 
@@ -166,16 +217,74 @@ func (p *Client) ReadLoop() error {
 			logger.DefaultLogger.Errorx("ReadLoop NextMsg error: %s", nil, err.Error())
 			return err
 		}
-		rpcData := &rpcmsg.Data{}
-		err = proto.Unmarshal(m.Data, rpcData)
-		if err != nil {
-			logger.DefaultLogger.Error(err.Error())
-			continue
+		if err := p.dispatch(m.Data); err == natsrpc.ErrWorkerClosed {
+			return err
 		}
-		p.worker.Post(func() {
+	}
+}
+
+// 并发handler交给limiter，其他投递到worker
+func (p *Client) dispatch(data []byte) error {
+	rpcData := &rpcmsg.Data{}
+	err := proto.Unmarshal(data, rpcData)
+	if err != nil {
+		logger.DefaultLogger.Error(err.Error())
+		return nil
+	}
+	if limiter := p.limiter(rpcData); limiter != nil {
+		err := limiter.Post(func() {
 			p.handle(rpcData)
 		})
+		if err != nil && err != natsrpc.ErrPoolClosed {
+			logger.DefaultLogger.Errorx("ReadLoop post msgid %d error: %s", nil, rpcData.Msgid, err.Error())
+		}
+		return nil
+	}
+	ctx, cancel := requestContext(rpcData)
+	err = p.worker.PostTask(natsrpc.Task{
+		Key:      shardKey(rpcData),
+		Source:   "rpc:" + p.processor.MsgName(rpcData.Msgid),
+		Priority: p.processor.GetPriority(rpcData.Msgid),
+		Ctx:      ctx,
+		F: func() {
+			defer cancel()
+			p.handle(rpcData)
+		},
+	})
+	if err != nil && err != natsrpc.ErrWorkerClosed {
+		logger.DefaultLogger.Errorx("ReadLoop post msgid %d error: %s", nil, rpcData.Msgid, err.Error())
+	}
+	return err
+}
+
+// request在worker中排队超过请求方的超时时间后不再执行
+func requestContext(rpcData *rpcmsg.Data) (context.Context, context.CancelFunc) {
+	if rpcData.Type != rpcmsg.Data_Request || rpcData.Timeout <= 0 {
+		return nil, func() {}
+	}
+	return context.WithTimeout(context.Background(), time.Duration(rpcData.Timeout)*time.Millisecond)
+}
+
+// worker分片的key，session相关的消息按session分片，其他按发送方分片
+func shardKey(rpcData *rpcmsg.Data) uint32 {
+	switch rpcData.Type {
+	case rpcmsg.Data_Server2Session, rpcmsg.Data_CloseSession, rpcmsg.Data_SessionRebind, rpcmsg.Data_GroupJoin, rpcmsg.Data_GroupLeave:
+		return uint32(rpcData.Sesid)
+	case rpcmsg.Data_Group2Session:
+		return natsrpc.CRC32Hash(rpcData.Group)
+	case rpcmsg.Data_Session2Server, rpcmsg.Data_SessionOpened, rpcmsg.Data_SessionClosed:
+		return uint32(rpcData.Senderid)<<16 ^ uint32(rpcData.Sesid)
+	}
+	return uint32(rpcData.Senderid)
+}
+
+// 标记为并发的handler不进入worker，返回nil表示走worker保证顺序
+func (p *Client) limiter(rpcData *rpcmsg.Data) *natsrpc.Limiter {
+	switch rpcData.Type {
+	case rpcmsg.Data_Request, rpcmsg.Data_Session2Server, rpcmsg.Data_Server2Server:
+		return p.processor.GetLimiter(rpcData.Msgid)
 	}
+	return nil
 }
 
 func (p *Client) handle(rpcData *rpcmsg.Data) {
@@ -187,8 +296,7 @@ func (p *Client) handle(rpcData *rpcmsg.Data) {
 
 	switch rpcData.Type {
 	case rpcmsg.Data_Request:
-		s := NewRequestServer(p, senderID, seqID)
-		err := p.processor.HandleRequest(s, msgID, data)
+		err := p.handleRequest(rpcData)
 		if err != nil {
 			logger.DefaultLogger.Error(err.Error())
 			return
@@ -200,7 +308,7 @@ func (p *Client) handle(rpcData *rpcmsg.Data) {
 			return
 		}
 	case rpcmsg.Data_Session2Server:
-		s := NewSession(p, senderID, sesID)
+		s := newBoundSession(p, senderID, sesID, rpcData.Userid, rpcData.Attrs)
 		err := p.processor.HandleSessionMsg(s, msgID, data)
 		if err != nil {
 			logger.DefaultLogger.Error(err.Error())
@@ -208,6 +316,28 @@ func (p *Client) handle(rpcData *rpcmsg.Data) {
 		}
 	case rpcmsg.Data_Server2Session:
 		p.send2Session(sesID, msgID, data)
+	case rpcmsg.Data_SessionOpened, rpcmsg.Data_SessionClosed:
+		p.handleSessionEvent(rpcData)
+	case rpcmsg.Data_SessionRebind:
+		if p.onRebind != nil {
+			p.onRebind(sesID, senderID, rpcData.Target)
+		}
+	case rpcmsg.Data_CloseSession:
+		if p.closeSession != nil {
+			p.closeSession(sesID, msgID, data)
+		}
+	case rpcmsg.Data_GroupJoin, rpcmsg.Data_GroupLeave:
+		if p.onGroup != nil {
+			p.onGroup(rpcData.Group, sesID, rpcData.Type == rpcmsg.Data_GroupJoin)
+		}
+	case rpcmsg.Data_Group2Session:
+		if p.send2Group != nil {
+			p.send2Group(rpcData.Group, msgID, data)
+		}
+	case rpcmsg.Data_Broadcast:
+		if p.onBroadcast != nil {
+			p.onBroadcast(msgID, data, rpcData.Attrs)
+		}
 	case rpcmsg.Data_Server2Server:
 		s := NewServer(p, senderID)
 		err := p.processor.HandleMsg(s, msgID, data)
@@ -220,6 +350,42 @@ func (p *Client) handle(rpcData *rpcmsg.Data) {
 	}
 }
 
+func (p *Client) handleRequest(rpcData *rpcmsg.Data) (err error) {
+	s := NewRequestServer(p, rpcData.Senderid, rpcData.Seqid)
+	if p.dedup == nil {
+		return p.processor.HandleRequest(s, rpcData.Msgid, rpcData.Data)
+	}
+
+	key := makeDedupKey(rpcData.Senderid, rpcData.Idemkey, rpcData.Seqid)
+	timeout := CALL_TIMEOUT
+	if rpcData.Timeout > 0 {
+		timeout = time.Duration(rpcData.Timeout) * time.Millisecond
+	}
+	isNew, resp := p.dedup.begin(key, rpcData.Seqid, timeout)
+	if !isNew {
+		if resp != nil {
+			s.Answer(resp)
+		}
+		return nil
+	}
+
+	ds := &dedupRequestServer{
+		requestserver: s.(*requestserver),
+		cache:         p.dedup,
+		key:           key,
+	}
+	// 返回错误或panic时删除，panic由worker恢复
+	ok := false
+	defer func() {
+		if !ok {
+			p.dedup.abort(key)
+		}
+	}()
+	err = p.processor.HandleRequest(ds, rpcData.Msgid, rpcData.Data)
+	ok = err == nil
+	return err
+}
+
 func (p *Client) publish(topic string, data []byte) error {
 	return p.conn.Publish(topic, data)
 }
@@ -229,6 +395,149 @@ func (p *Client) RouteSession2Server(topic string, sesID int32, msg proto.Messag
 	p.publish(topic, data)
 }
 
+func (p *Client) RouteSession(topic string, s natsrpc.Session, msg proto.Message) {
+	data := MakeSessionRouteData(msg, s.ID(), s.UserID(), s.Bound(), p.serverID)
+	p.publish(topic, data)
+}
+
 func (p *Client) RegisterSend2Session(send2Session func(sesID int32, msgID uint32, data []byte)) {
 	p.send2Session = send2Session
 }
+
+func (p *Client) RegisterRebind(onRebind func(sesID int32, from int32, to int32)) {
+	p.onRebind = onRebind
+}
+
+func (p *Client) Rebind(gateTopic string, sesID int32, target int32) {
+	p.publish(gateTopic, MakeSessionRebindData(sesID, target, p.serverID))
+}
+
+func (p *Client) RegisterCloseSession(closeSession func(sesID int32, msgID uint32, data []byte)) {
+	p.closeSession = closeSession
+}
+
+func (p *Client) CloseSession(gateTopic string, sesID int32, goodbye proto.Message) {
+	p.publish(gateTopic, MakeCloseSessionData(sesID, goodbye, p.serverID))
+}
+
+func (p *Client) RegisterGroup(onGroup func(group string, sesID int32, join bool), send2Group func(group string, msgID uint32, data []byte)) {
+	p.onGroup = onGroup
+	p.send2Group = send2Group
+}
+
+func (p *Client) JoinGroup(gateTopic string, group string, sesID int32) {
+	p.publish(gateTopic, MakeGroupMemberData(true, group, sesID, p.serverID))
+}
+
+func (p *Client) LeaveGroup(gateTopic string, group string, sesID int32) {
+	p.publish(gateTopic, MakeGroupMemberData(false, group, sesID, p.serverID))
+}
+
+// 只发布一次，订阅了该分组的gate各收到一次
+func (p *Client) Multicast(group string, msg proto.Message) error {
+	if err := natsrpc.CheckGroupName(group); err != nil {
+		return err
+	}
+	return p.publish(groupSubject(group), MakeGroup2SessionData(msg, group, p.serverID))
+}
+
+// gate上的分组有成员时订阅
+func (p *Client) SubscribeGroup(group string) error {
+	p.groupMu.Lock()
+	defer p.groupMu.Unlock()
+	select {
+	case <-p.close:
+		return ErrClientClosed
+	default:
+	}
+	if _, ok := p.groupSubs[group]; ok {
+		return nil
+	}
+	sub, err := p.conn.Subscribe(groupSubject(group), func(m *nats.Msg) {
+		p.dispatch(m.Data)
+	})
+	if err != nil {
+		return err
+	}
+	p.groupSubs[group] = sub
+	// 等待nats服务器确认订阅，加入分组后立即Multicast的消息不会丢失
+	return p.conn.Flush()
+}
+
+func (p *Client) UnsubscribeGroup(group string) error {
+	p.groupMu.Lock()
+	defer p.groupMu.Unlock()
+	sub, ok := p.groupSubs[group]
+	if !ok {
+		return nil
+	}
+	delete(p.groupSubs, group)
+	return sub.Unsubscribe()
+}
+
+// 订阅广播，需要在Run之前调用
+func (p *Client) RegisterBroadcast(onBroadcast func(msgID uint32, data []byte, filter map[string]string)) error {
+	sub, err := p.conn.Subscribe(broadcastSubject, func(m *nats.Msg) {
+		p.dispatch(m.Data)
+	})
+	if err != nil {
+		return err
+	}
+	p.onBroadcast = onBroadcast
+	p.broadcastSub = sub
+	return nil
+}
+
+// 只发布一次，所有gate各收到一次
+func (p *Client) Broadcast(msg proto.Message, filter map[string]string) error {
+	return p.publish(broadcastSubject, MakeBroadcastData(msg, filter, p.serverID))
+}
+
+func (p *Client) handleSessionEvent(rpcData *rpcmsg.Data) {
+	// 订阅了全局事件时，直接发送的事件会重复
+	if p.sessionEventSub != nil && !rpcData.Global {
+		return
+	}
+	f := p.onSessionClosed
+	if rpcData.Type == rpcmsg.Data_SessionOpened {
+		f = p.onSessionOpened
+	}
+	if f != nil {
+		f(newBoundSession(p, rpcData.Senderid, rpcData.Sesid, rpcData.Userid, rpcData.Attrs))
+	}
+}
+
+func (p *Client) RegisterSessionEvent(onOpened, onClosed func(Session)) {
+	p.onSessionOpened = onOpened
+	p.onSessionClosed = onClosed
+}
+
+// 订阅所有gate的session事件，不管session是否路由到本服务器，需要在Run之前调用
+func (p *Client) SubscribeSessionEvents() error {
+	sub, err := p.conn.Subscribe(sessionEventSubject, func(m *nats.Msg) {
+		p.dispatch(m.Data)
+	})
+	if err != nil {
+		return err
+	}
+	p.sessionEventSub = sub
+	return nil
+}
+
+// gate服使用，opened为false时表示session断开
+func (p *Client) SendSessionEvent(topic string, s natsrpc.Session, opened bool) {
+	p.publish(topic, MakeSessionEventData(opened, false, s.ID(), s.UserID(), s.Bound(), p.serverID))
+}
+
+func (p *Client) PublishSessionEvent(s natsrpc.Session, opened bool) {
+	p.publish(sessionEventSubject, MakeSessionEventData(opened, true, s.ID(), s.UserID(), s.Bound(), p.serverID))
+}
+
+const (
+	broadcastSubject    = "broadcast"
+	sessionEventSubject = "session.events"
+)
+
+func groupSubject(group string) string {
+	return "group." + group
+}
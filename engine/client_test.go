@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHandleCloseSession(t *testing.T) {
+	type closed struct {
+		sesID int32
+		msgID uint32
+		kick  *rpcmsg.Kick
+	}
+	var ret []closed
+	p := &Client{}
+	p.RegisterCloseSession(func(sesID int32, msgID uint32, data []byte) {
+		c := closed{sesID: sesID, msgID: msgID}
+		if msgID != 0 {
+			c.kick = &rpcmsg.Kick{}
+			if err := proto.Unmarshal(data, c.kick); err != nil {
+				t.Error(err)
+			}
+		}
+		ret = append(ret, c)
+	})
+
+	for _, data := range [][]byte{
+		MakeCloseSessionData(7, nil, 2),
+		MakeCloseSessionData(7, &rpcmsg.Kick{Code: rpcmsg.Kick_Kicked, Reason: "cheat"}, 2),
+	} {
+		rpcData := &rpcmsg.Data{}
+		if err := proto.Unmarshal(data, rpcData); err != nil {
+			t.Fatal(err)
+		}
+		if shardKey(rpcData) != 7 {
+			t.Errorf("shard key not equal: %d", shardKey(rpcData))
+		}
+		p.handle(rpcData)
+	}
+
+	if len(ret) != 2 || ret[0].sesID != 7 || ret[0].msgID != 0 {
+		t.Fatalf("close not equal: %v", ret)
+	}
+	if ret[1].kick == nil || ret[1].kick.Reason != "cheat" || ret[1].kick.Code != rpcmsg.Kick_Kicked {
+		t.Errorf("goodbye not equal: %v", ret[1].kick)
+	}
+}
+
+func TestHandleSessionEvent(t *testing.T) {
+	var events []string
+	p := &Client{}
+	p.RegisterSessionEvent(func(s Session) {
+		events = append(events, "open:"+s.Attr("role"))
+	}, func(s Session) {
+		if s.UserID() != 100 || s.GateSessionID() != (GateSessionID{GateID: 1, SesID: 7}) {
+			t.Errorf("session not equal: %v %d", s.GateSessionID(), s.UserID())
+		}
+		events = append(events, "close")
+	})
+	handle := func(opened, global bool) {
+		rpcData := &rpcmsg.Data{}
+		proto.Unmarshal(MakeSessionEventData(opened, global, 7, 100, map[string]string{"role": "gm"}, 1), rpcData)
+		p.handle(rpcData)
+	}
+
+	handle(true, false)
+	handle(false, false)
+	// 订阅全局事件后忽略直接发送的事件
+	p.sessionEventSub = &nats.Subscription{}
+	handle(false, false)
+	handle(false, true)
+
+	if len(events) != 3 || events[0] != "open:gm" || events[1] != "close" || events[2] != "close" {
+		t.Errorf("events not equal: %v", events)
+	}
+}
+
+func TestHandleServer2SessionRaw(t *testing.T) {
+	kick := &rpcmsg.Kick{Reason: "raw"}
+	msgID, _ := natsrpc.ProtoHash(kick)
+	raw, _ := proto.Marshal(kick)
+	if !bytes.Equal(MakeServer2SessionRawData(msgID, raw, 7, 2), MakeServer2SessionData(kick, 7, 2)) {
+		t.Error("raw data not equal")
+	}
+
+	var gotID uint32
+	var got []byte
+	p := &Client{}
+	p.RegisterSend2Session(func(sesID int32, msgID uint32, data []byte) {
+		gotID, got = msgID, data
+	})
+	// 没有注册的消息类型也原样转发
+	rpcData := &rpcmsg.Data{}
+	proto.Unmarshal(MakeServer2SessionRawData(0xfffffff0, []byte{1, 2, 3}, 7, 2), rpcData)
+	p.handle(rpcData)
+	if gotID != 0xfffffff0 || !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("raw not equal: %x %v", gotID, got)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	p := &Client{
+		pool:      natsrpc.NewPool(1),
+		close:     make(chan struct{}),
+		groupSubs: map[string]*nats.Subscription{"room.1": {}},
+	}
+	// 没有Run时重复Close不阻塞
+	done := make(chan struct{})
+	go func() {
+		p.Close()
+		p.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked")
+	}
+	if len(p.groupSubs) != 0 {
+		t.Errorf("group subs not unsubscribed: %v", p.groupSubs)
+	}
+	if err := p.SubscribeGroup("room.2"); err != ErrClientClosed {
+		t.Errorf("subscribe after close: %v", err)
+	}
+}
+
+// worker统计中rpc消息名与gate一致，使用proto全名
+func TestProcessorMsgName(t *testing.T) {
+	p := NewProcessor()
+	p.RegisterRequestMsgHandler((*rpcmsg.Kick)(nil), func(RequestServer, proto.Message) {})
+	msgID, _ := natsrpc.ProtoHash(&rpcmsg.Kick{})
+	if name := p.MsgName(msgID); name != string(proto.MessageName(&rpcmsg.Kick{})) {
+		t.Errorf("msg name not equal: %s", name)
+	}
+}
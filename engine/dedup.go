@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type dedupKey struct {
+	senderID int32
+	key      string
+	seqID    int32 //没有幂等key时使用
+}
+
+type dedupEntry struct {
+	expire  time.Time
+	keep    time.Duration //应答后保留的时间
+	done    bool
+	resp    proto.Message
+	waiters []int32 //处理中时收到的重复请求seqid，应答时一起回复
+}
+
+// request去重缓存，key为发送方serverid+幂等key，没有幂等key时为发送方serverid+seqid
+// 重复请求不会再次调用handler，直接回放缓存的应答
+// seqid在发送方重启后从头开始，按seqid去重的应答只保留到请求超时，只用于过滤nats重复投递的同一个请求
+type dedupCache struct {
+	sync.Mutex
+	ttl       time.Duration
+	items     map[dedupKey]*dedupEntry
+	lastSweep time.Time
+}
+
+func newDedupCache(ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		ttl:       ttl,
+		items:     make(map[dedupKey]*dedupEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func makeDedupKey(senderID int32, idemKey string, seqID int32) dedupKey {
+	if idemKey != "" {
+		return dedupKey{senderID: senderID, key: idemKey}
+	}
+	return dedupKey{senderID: senderID, seqID: seqID}
+}
+
+// 返回true表示是新请求，需要调用handler
+// 重复请求已有应答时返回缓存的应答，处理中时记录seqid等待应答
+// timeout为请求的超时时间，超过后仍未应答时认为handler不会再应答，之后的重复请求重新执行
+func (p *dedupCache) begin(key dedupKey, seqID int32, timeout time.Duration) (bool, proto.Message) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	p.sweep(now)
+
+	if e, ok := p.items[key]; ok && now.Before(e.expire) {
+		if e.done {
+			return false, e.resp
+		}
+		e.waiters = append(e.waiters, seqID)
+		return false, nil
+	}
+
+	keep := p.ttl
+	if key.key == "" {
+		keep = timeout
+	}
+	p.items[key] = &dedupEntry{expire: now.Add(timeout), keep: keep}
+	return true, nil
+}
+
+// 记录应答，返回等待中的重复请求seqid
+func (p *dedupCache) finish(key dedupKey, resp proto.Message) []int32 {
+	p.Lock()
+	defer p.Unlock()
+
+	e, ok := p.items[key]
+	if !ok {
+		e = &dedupEntry{keep: p.ttl}
+		p.items[key] = e
+	}
+	waiters := e.waiters
+	e.done = true
+	e.resp = proto.Clone(resp)
+	e.waiters = nil
+	e.expire = time.Now().Add(e.keep)
+	return waiters
+}
+
+// handler执行失败或panic时删除，重试时可以再次执行，已经应答的保留
+func (p *dedupCache) abort(key dedupKey) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.items[key]; ok && !e.done {
+		delete(p.items, key)
+	}
+}
+
+func (p *dedupCache) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.ttl {
+		return
+	}
+	p.lastSweep = now
+	for k, e := range p.items {
+		if !now.Before(e.expire) {
+			delete(p.items, k)
+		}
+	}
+}
+
+// 应答时写入去重缓存
+type dedupRequestServer struct {
+	*requestserver
+	cache *dedupCache
+	key   dedupKey
+}
+
+func (p *dedupRequestServer) Answer(msg proto.Message) {
+	waiters := p.cache.finish(p.key, msg)
+	p.requestserver.Answer(msg)
+	for _, seqID := range waiters {
+		p.rpcClient.Answer(p.serverTopic, seqID, msg)
+	}
+}
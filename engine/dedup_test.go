@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDedupCache(t *testing.T) {
+	c := newDedupCache(time.Minute)
+	key := makeDedupKey(1, "grant-item-1", 0)
+
+	if isNew, _ := c.begin(key, 10, time.Minute); !isNew {
+		t.Error("first request should be new")
+		return
+	}
+	if isNew, resp := c.begin(key, 11, time.Minute); isNew || resp != nil {
+		t.Error("duplicate in flight should wait for answer")
+		return
+	}
+
+	waiters := c.finish(key, &rpcmsg.Data{Seqid: 10})
+	if len(waiters) != 1 || waiters[0] != 11 {
+		t.Errorf("waiters not equal: %v", waiters)
+		return
+	}
+
+	isNew, resp := c.begin(key, 12, time.Minute)
+	if isNew || resp == nil {
+		t.Error("duplicate after answer should replay response")
+		return
+	}
+	if !proto.Equal(resp, &rpcmsg.Data{Seqid: 10}) {
+		t.Error("replayed response not equal")
+		return
+	}
+
+	if isNew, _ := c.begin(makeDedupKey(2, "grant-item-1", 0), 10, time.Minute); !isNew {
+		t.Error("same key from other sender should be new")
+		return
+	}
+
+	// 已应答的不会被删除
+	c.abort(key)
+	if isNew, _ := c.begin(key, 13, time.Minute); isNew {
+		t.Error("answered request should not run again")
+		return
+	}
+
+	key = makeDedupKey(1, "grant-item-3", 0)
+	c.begin(key, 14, time.Minute)
+	c.abort(key)
+	if isNew, _ := c.begin(key, 15, time.Minute); !isNew {
+		t.Error("aborted request should run again")
+		return
+	}
+
+	// 超过请求超时仍未应答时重新执行
+	key = makeDedupKey(1, "grant-item-4", 0)
+	c.begin(key, 16, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if isNew, _ := c.begin(key, 17, time.Minute); !isNew {
+		t.Error("stale in-flight request should run again")
+		return
+	}
+}
+
+func TestDedupCacheExpire(t *testing.T) {
+	c := newDedupCache(10 * time.Millisecond)
+	key := makeDedupKey(1, "grant-item-2", 0)
+	c.begin(key, 10, time.Minute)
+	c.finish(key, &rpcmsg.Data{})
+
+	time.Sleep(20 * time.Millisecond)
+	if isNew, _ := c.begin(key, 10, time.Minute); !isNew {
+		t.Error("expired entry should not dedup")
+		return
+	}
+}
+
+// handler panic时删除处理中的记录，重试时再次执行
+func TestHandleRequestDedupPanic(t *testing.T) {
+	p := &Client{processor: NewProcessor(), dedup: newDedupCache(time.Minute)}
+	calls := 0
+	p.processor.RegisterRequestMsgHandler(&rpcmsg.Kick{}, func(s RequestServer, msg proto.Message) {
+		calls++
+		panic("handler panic")
+	})
+	msgID, _ := natsrpc.ProtoHash(&rpcmsg.Kick{})
+	handle := func(idemKey string, seqID int32) {
+		defer func() { recover() }()
+		p.handle(&rpcmsg.Data{Type: rpcmsg.Data_Request, Msgid: msgID, Senderid: 1, Seqid: seqID, Idemkey: idemKey, Timeout: 1000})
+	}
+
+	handle("grant-item-1", 1)
+	handle("grant-item-1", 2)
+	if calls != 2 {
+		t.Errorf("retry after panic should run again: %d", calls)
+	}
+	if _, ok := p.dedup.items[makeDedupKey(1, "grant-item-1", 0)]; ok {
+		t.Error("in-flight entry not removed")
+	}
+
+	handle("", 3)
+	handle("", 3)
+	if calls != 4 || len(p.dedup.items) != 0 {
+		t.Errorf("seqid entry not removed: %d %v", calls, p.dedup.items)
+	}
+}
+
+// 没有幂等key时按发送方+seqid去重，应答只保留到请求超时
+func TestHandleRequestDedupSeqID(t *testing.T) {
+	p := &Client{processor: NewProcessor(), dedup: newDedupCache(time.Minute)}
+	calls := 0
+	p.processor.RegisterRequestMsgHandler(&rpcmsg.Kick{}, func(s RequestServer, msg proto.Message) {
+		calls++
+		s.Answer(&rpcmsg.Kick{Reason: "ok"})
+	})
+	msgID, _ := natsrpc.ProtoHash(&rpcmsg.Kick{})
+	handle := func(senderID int32, seqID int32) {
+		p.handle(&rpcmsg.Data{Type: rpcmsg.Data_Request, Msgid: msgID, Senderid: senderID, Seqid: seqID, Timeout: 10})
+	}
+
+	handle(1, 1)
+	handle(1, 1) //重复投递
+	handle(1, 2)
+	handle(2, 1)
+	if calls != 3 {
+		t.Errorf("seqid dedup calls not equal: %d", calls)
+	}
+	e := p.dedup.items[makeDedupKey(1, "", 1)]
+	if e == nil || !e.done || time.Until(e.expire) > 10*time.Millisecond {
+		t.Errorf("seqid entry not equal: %+v", e)
+	}
+
+	// 超过请求超时后相同seqid(如发送方重启)重新执行
+	time.Sleep(20 * time.Millisecond)
+	handle(1, 1)
+	if calls != 4 {
+		t.Errorf("expired seqid should run again: %d", calls)
+	}
+}
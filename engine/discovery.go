@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+)
+
+const (
+	// 服务器发布ServerInfo的间隔，超过3倍间隔没有收到时认为已下线
+	AnnounceInterval = 2 * time.Second
+	serverExpire     = 3 * AnnounceInterval
+	hashReplicas     = 64
+	discoverySubject = "discovery"
+)
+
+// 按服务器类型选择实例的方式
+type Balance int
+
+const (
+	BalanceHash      Balance = iota //按key一致性哈希，服务器增减时只影响少量key
+	BalanceLeastLoad                //选择worker中等待任务最少的服务器，本地每选择一次负载加1，收到新的负载时重置
+)
+
+type serverEntry struct {
+	typ      ServerType
+	load     int32
+	assigned int32 //原子操作，上次发布负载后本地选择的次数
+	seen     time.Time
+}
+
+// 已发现的服务器，通过nats回调更新，可以在任意协程中访问
+type registry struct {
+	mu      sync.RWMutex
+	servers map[int32]*serverEntry
+	rings   map[ServerType]*hashRing
+}
+
+func newRegistry() *registry {
+	return &registry{
+		servers: make(map[int32]*serverEntry),
+		rings:   make(map[ServerType]*hashRing),
+	}
+}
+
+func (p *registry) update(info *rpcmsg.ServerInfo, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	typ := ServerType(info.Type)
+	old, ok := p.servers[info.Id]
+	if info.Leave {
+		if ok {
+			delete(p.servers, info.Id)
+			p.rebuild(old.typ)
+		}
+		return
+	}
+	p.servers[info.Id] = &serverEntry{typ: typ, load: info.Load, seen: now}
+	if !ok || old.typ != typ {
+		p.rebuild(typ)
+		if ok {
+			p.rebuild(old.typ)
+		}
+	}
+}
+
+// 移除超时没有发布的服务器
+func (p *registry) expire(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := map[ServerType]bool{}
+	for id, v := range p.servers {
+		if now.Sub(v.seen) > serverExpire {
+			delete(p.servers, id)
+			changed[v.typ] = true
+		}
+	}
+	for typ := range changed {
+		p.rebuild(typ)
+	}
+}
+
+// 需要持有写锁
+func (p *registry) rebuild(typ ServerType) {
+	var ids []int32
+	for id, v := range p.servers {
+		if v.typ == typ {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		delete(p.rings, typ)
+		return
+	}
+	p.rings[typ] = newHashRing(ids)
+}
+
+func (p *registry) pick(typ ServerType, balance Balance, key uint64) (int32, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if balance == BalanceLeastLoad {
+		// 负载每AnnounceInterval发布一次，加上本地选择的次数避免期间全部选择同一个服务器
+		var ret *serverEntry
+		var retID int32
+		var load int32
+		for id, v := range p.servers {
+			if v.typ != typ {
+				continue
+			}
+			n := v.load + atomic.LoadInt32(&v.assigned)
+			// 负载相同时选择id小的，结果稳定
+			if ret == nil || n < load || n == load && id < retID {
+				ret, retID, load = v, id, n
+			}
+		}
+		if ret == nil {
+			return 0, false
+		}
+		atomic.AddInt32(&ret.assigned, 1)
+		return retID, true
+	}
+	ring, ok := p.rings[typ]
+	if !ok {
+		return 0, false
+	}
+	return ring.get(key), true
+}
+
+func (p *registry) serverType(id int32) (ServerType, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	v, ok := p.servers[id]
+	if !ok {
+		return 0, false
+	}
+	return v.typ, true
+}
+
+func (p *registry) ids(typ ServerType) []int32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var ret []int32
+	for id, v := range p.servers {
+		if v.typ == typ {
+			ret = append(ret, id)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// 一致性哈希环，每个服务器hashReplicas个虚拟节点
+type hashRing struct {
+	hashes []uint32
+	nodes  map[uint32]int32
+}
+
+func newHashRing(ids []int32) *hashRing {
+	p := &hashRing{nodes: make(map[uint32]int32, len(ids)*hashReplicas)}
+	for _, id := range ids {
+		for i := 0; i < hashReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(int(id)) + "#" + strconv.Itoa(i)))
+			// 冲突时保留id小的，与添加顺序无关
+			if old, ok := p.nodes[h]; ok && old < id {
+				continue
+			}
+			if _, ok := p.nodes[h]; !ok {
+				p.hashes = append(p.hashes, h)
+			}
+			p.nodes[h] = id
+		}
+	}
+	sort.Slice(p.hashes, func(i, j int) bool { return p.hashes[i] < p.hashes[j] })
+	return p
+}
+
+func (p *hashRing) get(key uint64) int32 {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], key)
+	h := crc32.ChecksumIEEE(buf[:])
+	i := sort.Search(len(p.hashes), func(i int) bool { return p.hashes[i] >= h })
+	if i == len(p.hashes) {
+		i = 0
+	}
+	return p.nodes[p.hashes[i]]
+}
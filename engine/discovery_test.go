@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+)
+
+func TestHashRing(t *testing.T) {
+	r1 := newHashRing([]int32{1, 2, 3})
+	r2 := newHashRing([]int32{3, 1, 2})
+	r3 := newHashRing([]int32{1, 2})
+	count := map[int32]int{}
+	moved := 0
+	for key := uint64(0); key < 3000; key++ {
+		id := r1.get(key)
+		count[id]++
+		if r2.get(key) != id {
+			t.Fatalf("ring not stable for key %d", key)
+		}
+		// 移除3后只有原来在3上的key变化
+		if id != 3 && r3.get(key) != id {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Errorf("keys moved: %d", moved)
+	}
+	for id, n := range count {
+		if n < 500 {
+			t.Errorf("server %d not balanced: %v", id, count)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	reg := newRegistry()
+	now := time.Now()
+	reg.update(&rpcmsg.ServerInfo{Id: 101, Type: int32(Game), Load: 5}, now)
+	reg.update(&rpcmsg.ServerInfo{Id: 102, Type: int32(Game), Load: 1}, now)
+	reg.update(&rpcmsg.ServerInfo{Id: 201, Type: int32(Users)}, now)
+
+	// 本地选择的次数计入负载，收到新的负载前不会全部选择102
+	picked := map[int32]int{}
+	for i := 0; i < 6; i++ {
+		id, ok := reg.pick(Game, BalanceLeastLoad, 0)
+		if !ok {
+			t.Fatal("least load not picked")
+		}
+		picked[id]++
+	}
+	if picked[102] != 5 || picked[101] != 1 {
+		t.Errorf("least load not equal: %v", picked)
+	}
+	reg.update(&rpcmsg.ServerInfo{Id: 102, Type: int32(Game), Load: 1}, now)
+	if id, _ := reg.pick(Game, BalanceLeastLoad, 0); id != 102 {
+		t.Errorf("assigned not reset: %d", id)
+	}
+	if id, _ := reg.pick(Game, BalanceHash, 7); id != 101 && id != 102 {
+		t.Errorf("hash not equal: %d", id)
+	}
+	if ids := reg.ids(Game); len(ids) != 2 || ids[0] != 101 {
+		t.Errorf("ids not equal: %v", ids)
+	}
+	if _, ok := reg.pick(Center, BalanceHash, 7); ok {
+		t.Error("unknown type picked")
+	}
+
+	reg.update(&rpcmsg.ServerInfo{Id: 102, Type: int32(Game), Leave: true}, now)
+	if id, _ := reg.pick(Game, BalanceHash, 7); id != 101 {
+		t.Errorf("left server picked: %d", id)
+	}
+	reg.update(&rpcmsg.ServerInfo{Id: 201, Type: int32(Users)}, now.Add(serverExpire))
+	reg.expire(now.Add(serverExpire + time.Second))
+	if _, ok := reg.serverType(101); ok {
+		t.Error("server not expired")
+	}
+	if typ, ok := reg.serverType(201); !ok || typ != Users {
+		t.Error("alive server expired")
+	}
+	if _, ok := reg.pick(Game, BalanceHash, 7); ok {
+		t.Error("expired server picked")
+	}
+}
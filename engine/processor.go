@@ -3,6 +3,7 @@ package engine
 import (
 	"errors"
 	"reflect"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
@@ -15,6 +16,8 @@ type Processor struct {
 	msgID2Request    map[uint32]*RequestInfo
 	msgID2ServerMsg  map[uint32]*ServerMsgInfo
 	msgID2SessionMsg map[uint32]*SessionMsgInfo
+	msgID2Limiter    map[uint32]*natsrpc.Limiter
+	msgID2Priority   map[uint32]natsrpc.Priority
 
 	seqID          int32
 	seqID2CallInfo sync.Map
@@ -22,16 +25,19 @@ type Processor struct {
 
 type RequestInfo struct {
 	msgType    reflect.Type
+	msgName    string //proto全名，与gate的worker统计一致
 	msgHandler RequestHandler
 }
 
 type ServerMsgInfo struct {
 	msgType    reflect.Type
+	msgName    string //proto全名，与gate的worker统计一致
 	msgHandler ServerMsgHandler
 }
 
 type SessionMsgInfo struct {
 	msgType    reflect.Type
+	msgName    string //proto全名，与gate的worker统计一致
 	msgHandler SessionMsgHandler
 }
 
@@ -44,6 +50,8 @@ func NewProcessor() *Processor {
 	p.msgID2Request = make(map[uint32]*RequestInfo)
 	p.msgID2ServerMsg = make(map[uint32]*ServerMsgInfo)
 	p.msgID2SessionMsg = make(map[uint32]*SessionMsgInfo)
+	p.msgID2Limiter = make(map[uint32]*natsrpc.Limiter)
+	p.msgID2Priority = make(map[uint32]natsrpc.Priority)
 
 	return p
 }
@@ -57,6 +65,7 @@ func (p *Processor) RegisterRequestMsgHandler(msg proto.Message, f RequestHandle
 
 	msgInfo := new(RequestInfo)
 	msgInfo.msgType = msgType
+	msgInfo.msgName = string(proto.MessageName(msg))
 	msgInfo.msgHandler = f
 	p.msgID2Request[msgID] = msgInfo
 }
@@ -97,6 +106,7 @@ func (p *Processor) RegisterServerMsgHandler(msg proto.Message, f ServerMsgHandl
 
 	msgInfo := new(ServerMsgInfo)
 	msgInfo.msgType = msgType
+	msgInfo.msgName = string(proto.MessageName(msg))
 	msgInfo.msgHandler = f
 	p.msgID2ServerMsg[msgID] = msgInfo
 }
@@ -138,6 +148,7 @@ func (p *Processor) RegisterSessionMsgHandler(msg proto.Message, f SessionMsgHan
 
 	msgInfo := new(SessionMsgInfo)
 	msgInfo.msgType = msgType
+	msgInfo.msgName = string(proto.MessageName(msg))
 	msgInfo.msgHandler = f
 	p.msgID2SessionMsg[msgID] = msgInfo
 }
@@ -169,6 +180,40 @@ func (p *Processor) RegisterSessionMsgHandler(msg proto.Message, f SessionMsgHan
 //	})
 //}
 
+// 设置后该消息的handler在协程池中并发执行
+func (p *Processor) SetLimiter(msg proto.Message, limiter *natsrpc.Limiter) {
+	msgID, _ := natsrpc.ProtoHash(msg)
+	p.msgID2Limiter[msgID] = limiter
+}
+
+func (p *Processor) GetLimiter(msgID uint32) *natsrpc.Limiter {
+	return p.msgID2Limiter[msgID]
+}
+
+// 设置消息在worker中的优先级，默认PriorityNormal
+func (p *Processor) SetPriority(msg proto.Message, pri natsrpc.Priority) {
+	msgID, _ := natsrpc.ProtoHash(msg)
+	p.msgID2Priority[msgID] = pri
+}
+
+func (p *Processor) GetPriority(msgID uint32) natsrpc.Priority {
+	return p.msgID2Priority[msgID]
+}
+
+// 用于worker统计，未注册的消息返回msgID
+func (p *Processor) MsgName(msgID uint32) string {
+	if v, ok := p.msgID2Request[msgID]; ok {
+		return v.msgName
+	}
+	if v, ok := p.msgID2ServerMsg[msgID]; ok {
+		return v.msgName
+	}
+	if v, ok := p.msgID2SessionMsg[msgID]; ok {
+		return v.msgName
+	}
+	return strconv.FormatUint(uint64(msgID), 10)
+}
+
 func (p *Processor) HandleRequest(server RequestServer, msgID uint32, data []byte) error {
 	msgInfo, ok := p.msgID2Request[msgID]
 	if !ok {
@@ -1,11 +1,15 @@
 package engine
 
 import (
+	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/wwqdrh/gokit/logger"
 	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -15,6 +19,12 @@ type RPC struct {
 	client     *Client
 	serverID   int32
 	worker     natsrpc.Worker
+
+	serverType   ServerType //不为0时定期发布ServerInfo
+	registry     *registry  //WatchServers后不为nil
+	discoverySub *nats.Subscription
+	discoverDone chan struct{}
+	closeOnce    sync.Once
 }
 
 func NewRPC(serverID int32, worker natsrpc.Worker, natsUrl string) (*RPC, error) {
@@ -27,6 +37,7 @@ func NewRPC(serverID int32, worker natsrpc.Worker, natsUrl string) (*RPC, error)
 	p.serverID = serverID
 	p.client = rpcClient
 	p.sid2server = make(map[int32]Server)
+	p.discoverDone = make(chan struct{})
 	return p, nil
 }
 
@@ -41,12 +52,29 @@ func NewRPC(serverID int32, worker natsrpc.Worker, natsUrl string) (*RPC, error)
 //	return nil
 //}
 
+func (p *RPC) ID() int32 {
+	return p.serverID
+}
+
 func (p *RPC) Run() {
 	p.client.Run()
+	if p.serverType != 0 {
+		p.announce(false)
+		go p.announceLoop()
+	}
 	//p.worker.Run()
 }
 
 func (p *RPC) Close() {
+	if p.discoverySub != nil {
+		p.discoverySub.Unsubscribe()
+	}
+	p.closeOnce.Do(func() {
+		if p.serverType != 0 {
+			p.announce(true)
+		}
+		close(p.discoverDone)
+	})
 	p.client.Close()
 }
 
@@ -90,6 +118,22 @@ func (p *RPC) RegisterRequestMsgHandler(cb interface{}) {
 	})
 }
 
+// 将消息的handler标记为并发执行，不再占用worker，limit为该handler的最大并发数
+// 并发handler中不能直接访问worker线程的数据，需要时通过worker.Post切回
+func (p *RPC) SetConcurrent(msg proto.Message, limit int) {
+	p.client.processor.SetLimiter(msg, p.client.pool.NewLimiter(limit))
+}
+
+// 设置消息在worker中的优先级，ping、关服、gm等控制消息可以设置为PriorityHigh
+func (p *RPC) SetPriority(msg proto.Message, pri natsrpc.Priority) {
+	p.client.processor.SetPriority(msg, pri)
+}
+
+// 开启request去重，ttl内相同发送方+幂等key的请求直接回放之前的应答，没有幂等key时请求超时内相同发送方+seqid的请求只执行一次，需要在Run之前调用
+func (p *RPC) EnableDedup(ttl time.Duration) {
+	p.client.dedup = newDedupCache(ttl)
+}
+
 func (p *RPC) GetServerById(serverID int32) Server {
 	p.Lock()
 	defer p.Unlock()
@@ -101,15 +145,167 @@ func (p *RPC) GetServerById(serverID int32) Server {
 	return s
 }
 
-// TODO add
-func (p *RPC) GetServerByType(serverType ServerType) Server {
+// 设置后Run时开始定期发布本服务器的类型和负载，其他服务器通过GetServerByType找到，需要在Run之前调用
+func (p *RPC) SetServerType(serverType ServerType) {
+	p.serverType = serverType
+}
+
+// 订阅服务发现，之后可以按类型查找服务器，需要在Run之前调用
+func (p *RPC) WatchServers() error {
+	reg := newRegistry()
+	sub, err := p.client.conn.Subscribe(discoverySubject, func(m *nats.Msg) {
+		info := &rpcmsg.ServerInfo{}
+		if err := proto.Unmarshal(m.Data, info); err != nil {
+			logger.DefaultLogger.Error(err.Error())
+			return
+		}
+		reg.update(info, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	p.registry = reg
+	p.discoverySub = sub
+	go func() {
+		ticker := time.NewTicker(AnnounceInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				reg.expire(now)
+			case <-p.discoverDone:
+				return
+			}
+		}
+	}()
 	return nil
 }
 
+func (p *RPC) announce(leave bool) {
+	info := &rpcmsg.ServerInfo{
+		Id:    p.serverID,
+		Type:  int32(p.serverType),
+		Load:  int32(p.worker.Len()),
+		Leave: leave,
+	}
+	data, _ := proto.Marshal(info)
+	p.client.publish(discoverySubject, data)
+}
+
+func (p *RPC) announceLoop() {
+	ticker := time.NewTicker(AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.announce(false)
+		case <-p.discoverDone:
+			return
+		}
+	}
+}
+
+// 负载最低的服务器，没有找到时返回nil，需要先调用WatchServers
+func (p *RPC) GetServerByType(serverType ServerType) Server {
+	return p.PickServer(serverType, BalanceLeastLoad, 0)
+}
+
+// 按balance选择serverType的一个服务器，BalanceHash时相同的key选择相同的服务器
+func (p *RPC) PickServer(serverType ServerType, balance Balance, key uint64) Server {
+	id, ok := p.PickServerID(serverType, balance, key)
+	if !ok {
+		return nil
+	}
+	return p.GetServerById(id)
+}
+
+func (p *RPC) PickServerID(serverType ServerType, balance Balance, key uint64) (int32, bool) {
+	if p.registry == nil {
+		return 0, false
+	}
+	return p.registry.pick(serverType, balance, key)
+}
+
+// 已发现的serverType的服务器id
+func (p *RPC) Servers(serverType ServerType) []int32 {
+	if p.registry == nil {
+		return nil
+	}
+	return p.registry.ids(serverType)
+}
+
+// 服务器是否在线及其类型
+func (p *RPC) ServerType(serverID int32) (ServerType, bool) {
+	if p.registry == nil {
+		return 0, false
+	}
+	return p.registry.serverType(serverID)
+}
+
 func (p *RPC) RegisterSend2Session(send2Session func(sesID int32, msgID uint32, data []byte)) {
 	p.client.RegisterSend2Session(send2Session)
 }
 
+// 路由过消息的gate session打开、断开时调用，在worker中执行
+// 断开时session已不能发送消息，用于清理该session在本服务器的状态
+func (p *RPC) RegisterSessionEvent(onOpened, onClosed func(Session)) {
+	p.client.RegisterSessionEvent(onOpened, onClosed)
+}
+
+// 接收所有gate的session事件，不再区分是否路由过，需要在Run之前调用
+func (p *RPC) SubscribeSessionEvents() error {
+	return p.client.SubscribeSessionEvents()
+}
+
+// gate服专用，发送给serverID的session事件
+func (p *RPC) SendSessionEvent(serverID int32, s natsrpc.Session, opened bool) {
+	p.client.SendSessionEvent(fmt.Sprintf("%v", serverID), s, opened)
+}
+
+// gate服专用，发布给订阅了所有session事件的服务器
+func (p *RPC) PublishSessionEvent(s natsrpc.Session, opened bool) {
+	p.client.PublishSessionEvent(s, opened)
+}
+
+// gate服专用，后端把session从from迁移到to
+func (p *RPC) RegisterRebind(onRebind func(sesID int32, from int32, to int32)) {
+	p.client.RegisterRebind(onRebind)
+}
+
+// gate服专用，后端断开session时msgID不为0则先发送该消息
+func (p *RPC) RegisterCloseSession(closeSession func(sesID int32, msgID uint32, data []byte)) {
+	p.client.RegisterCloseSession(closeSession)
+}
+
+// gate服专用，处理后端发来的分组成员变化和分组消息
+func (p *RPC) RegisterGroup(onGroup func(group string, sesID int32, join bool), send2Group func(group string, msgID uint32, data []byte)) {
+	p.client.RegisterGroup(onGroup, send2Group)
+}
+
+// gate服专用，本gate上的分组有成员时订阅分组消息
+func (p *RPC) SubscribeGroup(group string) error {
+	return p.client.SubscribeGroup(group)
+}
+
+func (p *RPC) UnsubscribeGroup(group string) error {
+	return p.client.UnsubscribeGroup(group)
+}
+
+// 发送给所有gate上的分组成员，只发布一次
+func (p *RPC) Multicast(group string, msg proto.Message) error {
+	return p.client.Multicast(group, msg)
+}
+
+// gate服专用，收到广播后发送给本地的session，需要在Run之前调用
+func (p *RPC) RegisterBroadcast(onBroadcast func(msgID uint32, data []byte, filter map[string]string)) error {
+	return p.client.RegisterBroadcast(onBroadcast)
+}
+
+// 发送给所有gate的所有session，filter不为空时只发送给绑定属性全部相同的session
+func (p *RPC) Broadcast(msg proto.Message, filter map[string]string) error {
+	return p.client.Broadcast(msg, filter)
+}
+
 func (p *RPC) Session(gateID, sesID int32) Session {
 	return NewSession(p.client, gateID, sesID)
 }
@@ -0,0 +1,260 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.21.4
+// source: actor.proto
+
+package rpcmsg
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// 跨服务器发送给actor的消息
+type ActorMsg struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Target       string     `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`              //目标actor id
+	Senderserver int32      `protobuf:"varint,2,opt,name=senderserver,proto3" json:"senderserver,omitempty"` //发送方actor所在服务器，为0时没有发送方actor
+	Senderid     string     `protobuf:"bytes,3,opt,name=senderid,proto3" json:"senderid,omitempty"`
+	Body         *anypb.Any `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+	Stop         bool       `protobuf:"varint,5,opt,name=stop,proto3" json:"stop,omitempty"` //停止actor
+}
+
+func (x *ActorMsg) Reset() {
+	*x = ActorMsg{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_actor_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ActorMsg) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActorMsg) ProtoMessage() {}
+
+func (x *ActorMsg) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActorMsg.ProtoReflect.Descriptor instead.
+func (*ActorMsg) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ActorMsg) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *ActorMsg) GetSenderserver() int32 {
+	if x != nil {
+		return x.Senderserver
+	}
+	return 0
+}
+
+func (x *ActorMsg) GetSenderid() string {
+	if x != nil {
+		return x.Senderid
+	}
+	return ""
+}
+
+func (x *ActorMsg) GetBody() *anypb.Any {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *ActorMsg) GetStop() bool {
+	if x != nil {
+		return x.Stop
+	}
+	return false
+}
+
+type ActorResp struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Body  *anypb.Any `protobuf:"bytes,1,opt,name=body,proto3" json:"body,omitempty"`
+	Error string     `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *ActorResp) Reset() {
+	*x = ActorResp{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_actor_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ActorResp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActorResp) ProtoMessage() {}
+
+func (x *ActorResp) ProtoReflect() protoreflect.Message {
+	mi := &file_actor_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActorResp.ProtoReflect.Descriptor instead.
+func (*ActorResp) Descriptor() ([]byte, []int) {
+	return file_actor_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ActorResp) GetBody() *anypb.Any {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *ActorResp) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_actor_proto protoreflect.FileDescriptor
+
+var file_actor_proto_rawDesc = []byte{
+	0x0a, 0x0b, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x72,
+	0x70, 0x63, 0x6d, 0x73, 0x67, 0x1a, 0x19, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x61, 0x6e, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x22, 0xa0, 0x01, 0x0a, 0x08, 0x41, 0x63, 0x74, 0x6f, 0x72, 0x4d, 0x73, 0x67, 0x12, 0x16, 0x0a,
+	0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x22, 0x0a, 0x0c, 0x73, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x73,
+	0x65, 0x72, 0x76, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0c, 0x73, 0x65, 0x6e,
+	0x64, 0x65, 0x72, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x6e,
+	0x64, 0x65, 0x72, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6e,
+	0x64, 0x65, 0x72, 0x69, 0x64, 0x12, 0x28, 0x0a, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x41, 0x6e, 0x79, 0x52, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x12,
+	0x12, 0x0a, 0x04, 0x73, 0x74, 0x6f, 0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x73,
+	0x74, 0x6f, 0x70, 0x22, 0x4b, 0x0a, 0x09, 0x41, 0x63, 0x74, 0x6f, 0x72, 0x52, 0x65, 0x73, 0x70,
+	0x12, 0x28, 0x0a, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x41, 0x6e, 0x79, 0x52, 0x04, 0x62, 0x6f, 0x64, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x42, 0x03, 0x5a, 0x01, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_actor_proto_rawDescOnce sync.Once
+	file_actor_proto_rawDescData = file_actor_proto_rawDesc
+)
+
+func file_actor_proto_rawDescGZIP() []byte {
+	file_actor_proto_rawDescOnce.Do(func() {
+		file_actor_proto_rawDescData = protoimpl.X.CompressGZIP(file_actor_proto_rawDescData)
+	})
+	return file_actor_proto_rawDescData
+}
+
+var file_actor_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_actor_proto_goTypes = []interface{}{
+	(*ActorMsg)(nil),  // 0: rpcmsg.ActorMsg
+	(*ActorResp)(nil), // 1: rpcmsg.ActorResp
+	(*anypb.Any)(nil), // 2: google.protobuf.Any
+}
+var file_actor_proto_depIdxs = []int32{
+	2, // 0: rpcmsg.ActorMsg.body:type_name -> google.protobuf.Any
+	2, // 1: rpcmsg.ActorResp.body:type_name -> google.protobuf.Any
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_actor_proto_init() }
+func file_actor_proto_init() {
+	if File_actor_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_actor_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ActorMsg); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_actor_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ActorResp); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_actor_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_actor_proto_goTypes,
+		DependencyIndexes: file_actor_proto_depIdxs,
+		MessageInfos:      file_actor_proto_msgTypes,
+	}.Build()
+	File_actor_proto = out.File
+	file_actor_proto_rawDesc = nil
+	file_actor_proto_goTypes = nil
+	file_actor_proto_depIdxs = nil
+}
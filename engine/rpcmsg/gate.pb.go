@@ -0,0 +1,417 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.21.4
+// source: gate.proto
+
+package rpcmsg
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Kick_Code int32
+
+const (
+	Kick_Unknown     Kick_Code = 0
+	Kick_AuthFailed  Kick_Code = 1 //认证失败，reason为认证服务器返回的原因
+	Kick_AuthTimeout Kick_Code = 2 //超时没有完成认证
+	Kick_Kicked      Kick_Code = 3 //被后端服务器断开，如重复登录、反作弊
+	Kick_IdleTimeout Kick_Code = 4 //超时没有收到消息
+	Kick_RateLimited Kick_Code = 5 //发送消息过快或消息过大
+)
+
+// Enum value maps for Kick_Code.
+var (
+	Kick_Code_name = map[int32]string{
+		0: "Unknown",
+		1: "AuthFailed",
+		2: "AuthTimeout",
+		3: "Kicked",
+		4: "IdleTimeout",
+		5: "RateLimited",
+	}
+	Kick_Code_value = map[string]int32{
+		"Unknown":     0,
+		"AuthFailed":  1,
+		"AuthTimeout": 2,
+		"Kicked":      3,
+		"IdleTimeout": 4,
+		"RateLimited": 5,
+	}
+)
+
+func (x Kick_Code) Enum() *Kick_Code {
+	p := new(Kick_Code)
+	*p = x
+	return p
+}
+
+func (x Kick_Code) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Kick_Code) Descriptor() protoreflect.EnumDescriptor {
+	return file_gate_proto_enumTypes[0].Descriptor()
+}
+
+func (Kick_Code) Type() protoreflect.EnumType {
+	return &file_gate_proto_enumTypes[0]
+}
+
+func (x Kick_Code) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Kick_Code.Descriptor instead.
+func (Kick_Code) EnumDescriptor() ([]byte, []int) {
+	return file_gate_proto_rawDescGZIP(), []int{0, 0}
+}
+
+// gate断开客户端前发送的通知
+type Kick struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Code   Kick_Code `protobuf:"varint,1,opt,name=code,proto3,enum=rpcmsg.Kick_Code" json:"code,omitempty"`
+	Reason string    `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (x *Kick) Reset() {
+	*x = Kick{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_gate_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Kick) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Kick) ProtoMessage() {}
+
+func (x *Kick) ProtoReflect() protoreflect.Message {
+	mi := &file_gate_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Kick.ProtoReflect.Descriptor instead.
+func (*Kick) Descriptor() ([]byte, []int) {
+	return file_gate_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Kick) GetCode() Kick_Code {
+	if x != nil {
+		return x.Code
+	}
+	return Kick_Unknown
+}
+
+func (x *Kick) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// 应用层心跳，收到Ping后原样回复Pong，发送方用time计算rtt
+type Ping struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time int64 `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"` //发送方的时间戳(ns)
+}
+
+func (x *Ping) Reset() {
+	*x = Ping{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_gate_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ping) ProtoMessage() {}
+
+func (x *Ping) ProtoReflect() protoreflect.Message {
+	mi := &file_gate_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ping.ProtoReflect.Descriptor instead.
+func (*Ping) Descriptor() ([]byte, []int) {
+	return file_gate_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Ping) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type Pong struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time int64 `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+}
+
+func (x *Pong) Reset() {
+	*x = Pong{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_gate_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Pong) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pong) ProtoMessage() {}
+
+func (x *Pong) ProtoReflect() protoreflect.Message {
+	mi := &file_gate_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pong.ProtoReflect.Descriptor instead.
+func (*Pong) Descriptor() ([]byte, []int) {
+	return file_gate_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Pong) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+// 消息超过频率或大小限制被丢弃
+type Throttled struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Msgid  uint32 `protobuf:"varint,1,opt,name=msgid,proto3" json:"msgid,omitempty"` //被丢弃的消息，消息过大时为0
+	Reason string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+}
+
+func (x *Throttled) Reset() {
+	*x = Throttled{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_gate_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Throttled) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Throttled) ProtoMessage() {}
+
+func (x *Throttled) ProtoReflect() protoreflect.Message {
+	mi := &file_gate_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Throttled.ProtoReflect.Descriptor instead.
+func (*Throttled) Descriptor() ([]byte, []int) {
+	return file_gate_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Throttled) GetMsgid() uint32 {
+	if x != nil {
+		return x.Msgid
+	}
+	return 0
+}
+
+func (x *Throttled) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_gate_proto protoreflect.FileDescriptor
+
+var file_gate_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x67, 0x61, 0x74, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x72, 0x70,
+	0x63, 0x6d, 0x73, 0x67, 0x22, 0xa9, 0x01, 0x0a, 0x04, 0x4b, 0x69, 0x63, 0x6b, 0x12, 0x25, 0x0a,
+	0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x11, 0x2e, 0x72, 0x70,
+	0x63, 0x6d, 0x73, 0x67, 0x2e, 0x4b, 0x69, 0x63, 0x6b, 0x2e, 0x43, 0x6f, 0x64, 0x65, 0x52, 0x04,
+	0x63, 0x6f, 0x64, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22, 0x62, 0x0a, 0x04,
+	0x43, 0x6f, 0x64, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x6e, 0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x10,
+	0x00, 0x12, 0x0e, 0x0a, 0x0a, 0x41, 0x75, 0x74, 0x68, 0x46, 0x61, 0x69, 0x6c, 0x65, 0x64, 0x10,
+	0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x41, 0x75, 0x74, 0x68, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x4b, 0x69, 0x63, 0x6b, 0x65, 0x64, 0x10, 0x03, 0x12, 0x0f,
+	0x0a, 0x0b, 0x49, 0x64, 0x6c, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x10, 0x04, 0x12,
+	0x0f, 0x0a, 0x0b, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x65, 0x64, 0x10, 0x05,
+	0x22, 0x1a, 0x0a, 0x04, 0x50, 0x69, 0x6e, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x22, 0x1a, 0x0a, 0x04,
+	0x50, 0x6f, 0x6e, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x22, 0x39, 0x0a, 0x09, 0x54, 0x68, 0x72, 0x6f,
+	0x74, 0x74, 0x6c, 0x65, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x6d, 0x73, 0x67, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x6d, 0x73, 0x67, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x72,
+	0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x42, 0x03, 0x5a, 0x01, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_gate_proto_rawDescOnce sync.Once
+	file_gate_proto_rawDescData = file_gate_proto_rawDesc
+)
+
+func file_gate_proto_rawDescGZIP() []byte {
+	file_gate_proto_rawDescOnce.Do(func() {
+		file_gate_proto_rawDescData = protoimpl.X.CompressGZIP(file_gate_proto_rawDescData)
+	})
+	return file_gate_proto_rawDescData
+}
+
+var file_gate_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_gate_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_gate_proto_goTypes = []interface{}{
+	(Kick_Code)(0),    // 0: rpcmsg.Kick.Code
+	(*Kick)(nil),      // 1: rpcmsg.Kick
+	(*Ping)(nil),      // 2: rpcmsg.Ping
+	(*Pong)(nil),      // 3: rpcmsg.Pong
+	(*Throttled)(nil), // 4: rpcmsg.Throttled
+}
+var file_gate_proto_depIdxs = []int32{
+	0, // 0: rpcmsg.Kick.code:type_name -> rpcmsg.Kick.Code
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_gate_proto_init() }
+func file_gate_proto_init() {
+	if File_gate_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_gate_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Kick); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_gate_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ping); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_gate_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Pong); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_gate_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Throttled); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_gate_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_gate_proto_goTypes,
+		DependencyIndexes: file_gate_proto_depIdxs,
+		EnumInfos:         file_gate_proto_enumTypes,
+		MessageInfos:      file_gate_proto_msgTypes,
+	}.Build()
+	File_gate_proto = out.File
+	file_gate_proto_rawDesc = nil
+	file_gate_proto_goTypes = nil
+	file_gate_proto_depIdxs = nil
+}
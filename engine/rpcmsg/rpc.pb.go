@@ -29,17 +29,33 @@ const (
 	Data_Session2Server Data_Type = 3
 	Data_Server2Session Data_Type = 4
 	Data_Server2Server  Data_Type = 5
+	Data_GroupJoin      Data_Type = 6 //后端把session加入gate上的分组
+	Data_GroupLeave     Data_Type = 7
+	Data_Group2Session  Data_Type = 8  //发送给分组内的所有session，每个有成员的gate收到一次
+	Data_Broadcast      Data_Type = 9  //发送给所有gate的所有session，attrs为过滤条件
+	Data_CloseSession   Data_Type = 10 //后端断开session，msgid、data不为空时先发送给客户端
+	Data_SessionOpened  Data_Type = 11 //第一次路由到服务器时发送给该服务器，带上绑定的用户和属性
+	Data_SessionClosed  Data_Type = 12 //session断开时发送给路由过的服务器
+	Data_SessionRebind  Data_Type = 13 //后端把session迁移到target，之后发给同类型服务器的消息路由到target
 )
 
 // Enum value maps for Data_Type.
 var (
 	Data_Type_name = map[int32]string{
-		0: "Invalid",
-		1: "Request",
-		2: "Response",
-		3: "Session2Server",
-		4: "Server2Session",
-		5: "Server2Server",
+		0:  "Invalid",
+		1:  "Request",
+		2:  "Response",
+		3:  "Session2Server",
+		4:  "Server2Session",
+		5:  "Server2Server",
+		6:  "GroupJoin",
+		7:  "GroupLeave",
+		8:  "Group2Session",
+		9:  "Broadcast",
+		10: "CloseSession",
+		11: "SessionOpened",
+		12: "SessionClosed",
+		13: "SessionRebind",
 	}
 	Data_Type_value = map[string]int32{
 		"Invalid":        0,
@@ -48,6 +64,14 @@ var (
 		"Session2Server": 3,
 		"Server2Session": 4,
 		"Server2Server":  5,
+		"GroupJoin":      6,
+		"GroupLeave":     7,
+		"Group2Session":  8,
+		"Broadcast":      9,
+		"CloseSession":   10,
+		"SessionOpened":  11,
+		"SessionClosed":  12,
+		"SessionRebind":  13,
 	}
 )
 
@@ -83,12 +107,19 @@ type Data struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Type     Data_Type `protobuf:"varint,1,opt,name=type,proto3,enum=rpcmsg.Data_Type" json:"type,omitempty"` //数据类型
-	Seqid    int32     `protobuf:"varint,2,opt,name=seqid,proto3" json:"seqid,omitempty"`                     //rpc相关时有用
-	Sesid    int32     `protobuf:"varint,3,opt,name=sesid,proto3" json:"sesid,omitempty"`                     //ses相关时有用
-	Senderid int32     `protobuf:"varint,4,opt,name=senderid,proto3" json:"senderid,omitempty"`               //发送方serverid
-	Msgid    uint32    `protobuf:"varint,5,opt,name=msgid,proto3" json:"msgid,omitempty"`
-	Data     []byte    `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`
+	Type     Data_Type         `protobuf:"varint,1,opt,name=type,proto3,enum=rpcmsg.Data_Type" json:"type,omitempty"` //数据类型
+	Seqid    int32             `protobuf:"varint,2,opt,name=seqid,proto3" json:"seqid,omitempty"`                     //rpc相关时有用
+	Sesid    int32             `protobuf:"varint,3,opt,name=sesid,proto3" json:"sesid,omitempty"`                     //ses相关时有用
+	Senderid int32             `protobuf:"varint,4,opt,name=senderid,proto3" json:"senderid,omitempty"`               //发送方serverid
+	Msgid    uint32            `protobuf:"varint,5,opt,name=msgid,proto3" json:"msgid,omitempty"`
+	Data     []byte            `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`
+	Idemkey  string            `protobuf:"bytes,7,opt,name=idemkey,proto3" json:"idemkey,omitempty"`                                                                                      //幂等key，request去重使用，为空时使用seqid，只在请求超时内去重
+	Timeout  int32             `protobuf:"varint,8,opt,name=timeout,proto3" json:"timeout,omitempty"`                                                                                     //request超时时间(ms)，超时后请求方已放弃，未执行的请求不再执行
+	Userid   int64             `protobuf:"varint,9,opt,name=userid,proto3" json:"userid,omitempty"`                                                                                       //Session2Server时有用，session绑定的用户
+	Attrs    map[string]string `protobuf:"bytes,10,rep,name=attrs,proto3" json:"attrs,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"` //Session2Server时为session绑定的属性，Broadcast时为过滤条件
+	Group    string            `protobuf:"bytes,11,opt,name=group,proto3" json:"group,omitempty"`                                                                                         //分组相关时有用
+	Global   bool              `protobuf:"varint,12,opt,name=global,proto3" json:"global,omitempty"`                                                                                      //SessionOpened、SessionClosed时有用，为true时发布给所有订阅了session事件的服务器
+	Target   int32             `protobuf:"varint,13,opt,name=target,proto3" json:"target,omitempty"`                                                                                      //SessionRebind时有用
 }
 
 func (x *Data) Reset() {
@@ -165,11 +196,132 @@ func (x *Data) GetData() []byte {
 	return nil
 }
 
+func (x *Data) GetIdemkey() string {
+	if x != nil {
+		return x.Idemkey
+	}
+	return ""
+}
+
+func (x *Data) GetTimeout() int32 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *Data) GetUserid() int64 {
+	if x != nil {
+		return x.Userid
+	}
+	return 0
+}
+
+func (x *Data) GetAttrs() map[string]string {
+	if x != nil {
+		return x.Attrs
+	}
+	return nil
+}
+
+func (x *Data) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Data) GetGlobal() bool {
+	if x != nil {
+		return x.Global
+	}
+	return false
+}
+
+func (x *Data) GetTarget() int32 {
+	if x != nil {
+		return x.Target
+	}
+	return 0
+}
+
+// 服务发现，服务器定期发布自己的类型和负载
+type ServerInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id    int32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type  int32 `protobuf:"varint,2,opt,name=type,proto3" json:"type,omitempty"`
+	Load  int32 `protobuf:"varint,3,opt,name=load,proto3" json:"load,omitempty"`   //worker中等待执行的任务数
+	Leave bool  `protobuf:"varint,4,opt,name=leave,proto3" json:"leave,omitempty"` //关闭时发布，其他服务器立即移除
+}
+
+func (x *ServerInfo) Reset() {
+	*x = ServerInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ServerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfo) ProtoMessage() {}
+
+func (x *ServerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfo.ProtoReflect.Descriptor instead.
+func (*ServerInfo) Descriptor() ([]byte, []int) {
+	return file_rpc_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ServerInfo) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetType() int32 {
+	if x != nil {
+		return x.Type
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetLoad() int32 {
+	if x != nil {
+		return x.Load
+	}
+	return 0
+}
+
+func (x *ServerInfo) GetLeave() bool {
+	if x != nil {
+		return x.Leave
+	}
+	return false
+}
+
 var File_rpc_proto protoreflect.FileDescriptor
 
 var file_rpc_proto_rawDesc = []byte{
 	0x0a, 0x09, 0x72, 0x70, 0x63, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x72, 0x70, 0x63,
-	0x6d, 0x73, 0x67, 0x22, 0x8a, 0x02, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x04,
+	0x6d, 0x73, 0x67, 0x22, 0x92, 0x05, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x04,
 	0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x11, 0x2e, 0x72, 0x70, 0x63,
 	0x6d, 0x73, 0x67, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74,
 	0x79, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x65, 0x71, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
@@ -179,14 +331,45 @@ var file_rpc_proto_rawDesc = []byte{
 	0x05, 0x52, 0x08, 0x73, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x6d,
 	0x73, 0x67, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x6d, 0x73, 0x67, 0x69,
 	0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52,
-	0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x69, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0b, 0x0a,
-	0x07, 0x49, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f,
-	0x6e, 0x73, 0x65, 0x10, 0x02, 0x12, 0x12, 0x0a, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
-	0x32, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x10, 0x03, 0x12, 0x12, 0x0a, 0x0e, 0x53, 0x65, 0x72,
-	0x76, 0x65, 0x72, 0x32, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x10, 0x04, 0x12, 0x11, 0x0a,
-	0x0d, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x32, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x10, 0x05,
-	0x42, 0x03, 0x5a, 0x01, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x69, 0x64, 0x65, 0x6d, 0x6b, 0x65, 0x79,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x69, 0x64, 0x65, 0x6d, 0x6b, 0x65, 0x79, 0x12,
+	0x18, 0x0a, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x75, 0x73, 0x65,
+	0x72, 0x69, 0x64, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x75, 0x73, 0x65, 0x72, 0x69,
+	0x64, 0x12, 0x2d, 0x0a, 0x05, 0x61, 0x74, 0x74, 0x72, 0x73, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x17, 0x2e, 0x72, 0x70, 0x63, 0x6d, 0x73, 0x67, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x2e, 0x41,
+	0x74, 0x74, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x05, 0x61, 0x74, 0x74, 0x72, 0x73,
+	0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x16, 0x0a, 0x06, 0x67, 0x6c, 0x6f, 0x62, 0x61, 0x6c,
+	0x18, 0x0c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x67, 0x6c, 0x6f, 0x62, 0x61, 0x6c, 0x12, 0x16,
+	0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06,
+	0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x1a, 0x38, 0x0a, 0x0a, 0x41, 0x74, 0x74, 0x72, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01,
+	0x22, 0xf5, 0x01, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x49, 0x6e, 0x76,
+	0x61, 0x6c, 0x69, 0x64, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x10, 0x01, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x10,
+	0x02, 0x12, 0x12, 0x0a, 0x0e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x32, 0x53, 0x65, 0x72,
+	0x76, 0x65, 0x72, 0x10, 0x03, 0x12, 0x12, 0x0a, 0x0e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x32,
+	0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x10, 0x04, 0x12, 0x11, 0x0a, 0x0d, 0x53, 0x65, 0x72,
+	0x76, 0x65, 0x72, 0x32, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x10, 0x05, 0x12, 0x0d, 0x0a, 0x09,
+	0x47, 0x72, 0x6f, 0x75, 0x70, 0x4a, 0x6f, 0x69, 0x6e, 0x10, 0x06, 0x12, 0x0e, 0x0a, 0x0a, 0x47,
+	0x72, 0x6f, 0x75, 0x70, 0x4c, 0x65, 0x61, 0x76, 0x65, 0x10, 0x07, 0x12, 0x11, 0x0a, 0x0d, 0x47,
+	0x72, 0x6f, 0x75, 0x70, 0x32, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x10, 0x08, 0x12, 0x0d,
+	0x0a, 0x09, 0x42, 0x72, 0x6f, 0x61, 0x64, 0x63, 0x61, 0x73, 0x74, 0x10, 0x09, 0x12, 0x10, 0x0a,
+	0x0c, 0x43, 0x6c, 0x6f, 0x73, 0x65, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x10, 0x0a, 0x12,
+	0x11, 0x0a, 0x0d, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4f, 0x70, 0x65, 0x6e, 0x65, 0x64,
+	0x10, 0x0b, 0x12, 0x11, 0x0a, 0x0d, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x6c, 0x6f,
+	0x73, 0x65, 0x64, 0x10, 0x0c, 0x12, 0x11, 0x0a, 0x0d, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x52, 0x65, 0x62, 0x69, 0x6e, 0x64, 0x10, 0x0d, 0x22, 0x5a, 0x0a, 0x0a, 0x53, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6c, 0x6f,
+	0x61, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x14,
+	0x0a, 0x05, 0x6c, 0x65, 0x61, 0x76, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x6c,
+	0x65, 0x61, 0x76, 0x65, 0x42, 0x03, 0x5a, 0x01, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
 }
 
 var (
@@ -202,18 +385,21 @@ func file_rpc_proto_rawDescGZIP() []byte {
 }
 
 var file_rpc_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_rpc_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_rpc_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_rpc_proto_goTypes = []interface{}{
-	(Data_Type)(0), // 0: rpcmsg.Data.Type
-	(*Data)(nil),   // 1: rpcmsg.Data
+	(Data_Type)(0),     // 0: rpcmsg.Data.Type
+	(*Data)(nil),       // 1: rpcmsg.Data
+	(*ServerInfo)(nil), // 2: rpcmsg.ServerInfo
+	nil,                // 3: rpcmsg.Data.AttrsEntry
 }
 var file_rpc_proto_depIdxs = []int32{
 	0, // 0: rpcmsg.Data.type:type_name -> rpcmsg.Data.Type
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	3, // 1: rpcmsg.Data.attrs:type_name -> rpcmsg.Data.AttrsEntry
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_rpc_proto_init() }
@@ -234,6 +420,18 @@ func file_rpc_proto_init() {
 				return nil
 			}
 		}
+		file_rpc_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ServerInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -241,7 +439,7 @@ func file_rpc_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_rpc_proto_rawDesc,
 			NumEnums:      1,
-			NumMessages:   1,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -3,6 +3,8 @@ package engine
 import (
 	"fmt"
 
+	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -20,9 +22,15 @@ type Server interface {
 	Notify(proto.Message)
 	Call(proto.Message, proto.Message) error
 	RouteSession2Server(sesID int32, msg proto.Message)
+	// 同RouteSession2Server，同时带上session绑定的用户和属性
+	RouteSession(s natsrpc.Session, msg proto.Message)
 
 	Request(proto.Message, interface{}) error
 
+	// 带幂等key，对端开启去重时相同key的请求只执行一次
+	CallWithKey(key string, req proto.Message, resp proto.Message) error
+	RequestWithKey(key string, msg proto.Message, cb interface{}) error
+
 	ID() int32
 }
 
@@ -57,11 +65,23 @@ func (p *server) Call(req proto.Message, resp proto.Message) error {
 	return p.rpcClient.Call(p.serverTopic, req, resp)
 }
 
+func (p *server) RequestWithKey(key string, msg proto.Message, f interface{}) error {
+	return p.rpcClient.RequestWithKey(p.serverTopic, key, msg, f)
+}
+
+func (p *server) CallWithKey(key string, req proto.Message, resp proto.Message) error {
+	return p.rpcClient.CallWithKey(p.serverTopic, key, req, resp)
+}
+
 // gate服使用较多，把消息路由到对应服务器
 func (p *server) RouteSession2Server(sesid int32, msg proto.Message) {
 	p.rpcClient.RouteSession2Server(p.serverTopic, sesid, msg)
 }
 
+func (p *server) RouteSession(s natsrpc.Session, msg proto.Message) {
+	p.rpcClient.RouteSession(p.serverTopic, s, msg)
+}
+
 type RequestServer interface {
 	Answer(proto.Message)
 	Server
@@ -94,8 +114,21 @@ func (p *requestserver) ID() int32 {
 
 type Session interface {
 	SendMsg(msg proto.Message)
-	SendRawMsg(msgID uint16, data []byte)
+	// 发送已经序列化的消息，msgID与natsrpc.ProtoHash一致，gate不解析直接转发给客户端
+	SendRawMsg(msgID uint32, data []byte)
 	GateSessionID() GateSessionID
+	// 通过gate断开客户端，reason不为空时客户端先收到rpcmsg.Kick
+	Close(reason string)
+	// 把session迁移到同类型的serverID，gate上按类型路由的消息之后发送到serverID
+	// gate没有发现serverID时忽略
+	Rebind(serverID int32)
+	// 加入gate上的分组，通过RPC.Multicast发送给分组内的所有session
+	JoinGroup(group string)
+	LeaveGroup(group string)
+	// gate通过RouteSession路由时绑定的用户和属性，其他方式创建的session为空
+	UserID() int64
+	Attr(key string) string
+	Attrs() map[string]string
 }
 
 type GateSessionID struct {
@@ -107,6 +140,8 @@ type session struct {
 	gsID      GateSessionID
 	rpcClient *Client
 	gateTopic string
+	userID    int64
+	attrs     map[string]string
 }
 
 func NewSession(client *Client, gateID int32, sesID int32) Session {
@@ -119,14 +154,53 @@ func NewSession(client *Client, gateID int32, sesID int32) Session {
 	}
 }
 
+func newBoundSession(client *Client, gateID int32, sesID int32, userID int64, attrs map[string]string) Session {
+	s := NewSession(client, gateID, sesID).(*session)
+	s.userID = userID
+	s.attrs = attrs
+	return s
+}
+
 func (p *session) SendMsg(msg proto.Message) {
 	p.rpcClient.RouteGate(p.gateTopic, p.gsID.SesID, msg)
 }
 
-func (p *session) SendRawMsg(msgID uint16, data []byte) {
+func (p *session) SendRawMsg(msgID uint32, data []byte) {
+	p.rpcClient.RouteGateRaw(p.gateTopic, p.gsID.SesID, msgID, data)
+}
 
+func (p *session) Close(reason string) {
+	var goodbye proto.Message
+	if reason != "" {
+		goodbye = &rpcmsg.Kick{Code: rpcmsg.Kick_Kicked, Reason: reason}
+	}
+	p.rpcClient.CloseSession(p.gateTopic, p.gsID.SesID, goodbye)
+}
+
+func (p *session) Rebind(serverID int32) {
+	p.rpcClient.Rebind(p.gateTopic, p.gsID.SesID, serverID)
+}
+
+func (p *session) JoinGroup(group string) {
+	p.rpcClient.JoinGroup(p.gateTopic, group, p.gsID.SesID)
+}
+
+func (p *session) LeaveGroup(group string) {
+	p.rpcClient.LeaveGroup(p.gateTopic, group, p.gsID.SesID)
 }
 
 func (p *session) GateSessionID() GateSessionID {
 	return p.gsID
 }
+
+func (p *session) UserID() int64 {
+	return p.userID
+}
+
+func (p *session) Attr(key string) string {
+	return p.attrs[key]
+}
+
+func (p *session) Attrs() map[string]string {
+	return p.attrs
+}
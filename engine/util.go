@@ -1,12 +1,18 @@
 package engine
 
 import (
+	"time"
+
 	"github.com/wwqdrh/natsrpc"
 	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
 	"google.golang.org/protobuf/proto"
 )
 
 func MakeRequestData(msg proto.Message, seqID int32, senderID int32) []byte {
+	return MakeIdempotentRequestData(msg, seqID, senderID, "")
+}
+
+func MakeIdempotentRequestData(msg proto.Message, seqID int32, senderID int32, idemKey string) []byte {
 	msgID, _ := natsrpc.ProtoHash(msg)
 	msgData, _ := proto.Marshal(msg)
 	rpc := &rpcmsg.Data{
@@ -15,6 +21,8 @@ func MakeRequestData(msg proto.Message, seqID int32, senderID int32) []byte {
 		Seqid:    seqID,
 		Senderid: senderID,
 		Data:     msgData,
+		Idemkey:  idemKey,
+		Timeout:  int32(CALL_TIMEOUT / time.Millisecond),
 	}
 
 	data, _ := proto.Marshal(rpc)
@@ -53,6 +61,11 @@ func MakeResponseData(msg proto.Message, seqID int32, senderID int32) []byte {
 func MakeServer2SessionData(msg proto.Message, sesID int32, senderID int32) []byte {
 	msgID, _ := natsrpc.ProtoHash(msg)
 	msgData, _ := proto.Marshal(msg)
+	return MakeServer2SessionRawData(msgID, msgData, sesID, senderID)
+}
+
+// msgData为已经序列化的消息
+func MakeServer2SessionRawData(msgID uint32, msgData []byte, sesID int32, senderID int32) []byte {
 	rpc := &rpcmsg.Data{
 		Type:     rpcmsg.Data_Server2Session,
 		Msgid:    msgID,
@@ -66,6 +79,11 @@ func MakeServer2SessionData(msg proto.Message, sesID int32, senderID int32) []by
 }
 
 func MakeSession2ServerData(msg proto.Message, sesID int32, senderID int32) []byte {
+	return MakeSessionRouteData(msg, sesID, 0, nil, senderID)
+}
+
+// 带上session绑定的用户和属性
+func MakeSessionRouteData(msg proto.Message, sesID int32, userID int64, attrs map[string]string, senderID int32) []byte {
 	msgID, _ := natsrpc.ProtoHash(msg)
 	msgData, _ := proto.Marshal(msg)
 	rpc := &rpcmsg.Data{
@@ -74,6 +92,103 @@ func MakeSession2ServerData(msg proto.Message, sesID int32, senderID int32) []by
 		Senderid: senderID,
 		Data:     msgData,
 		Sesid:    sesID,
+		Userid:   userID,
+		Attrs:    attrs,
+	}
+
+	data, _ := proto.Marshal(rpc)
+	return data
+}
+
+// 加入或离开分组，发送到session所在的gate
+func MakeGroupMemberData(join bool, group string, sesID int32, senderID int32) []byte {
+	typ := rpcmsg.Data_GroupLeave
+	if join {
+		typ = rpcmsg.Data_GroupJoin
+	}
+	rpc := &rpcmsg.Data{
+		Type:     typ,
+		Senderid: senderID,
+		Sesid:    sesID,
+		Group:    group,
+	}
+
+	data, _ := proto.Marshal(rpc)
+	return data
+}
+
+func MakeGroup2SessionData(msg proto.Message, group string, senderID int32) []byte {
+	msgID, _ := natsrpc.ProtoHash(msg)
+	msgData, _ := proto.Marshal(msg)
+	rpc := &rpcmsg.Data{
+		Type:     rpcmsg.Data_Group2Session,
+		Msgid:    msgID,
+		Senderid: senderID,
+		Data:     msgData,
+		Group:    group,
+	}
+
+	data, _ := proto.Marshal(rpc)
+	return data
+}
+
+func MakeBroadcastData(msg proto.Message, filter map[string]string, senderID int32) []byte {
+	msgID, _ := natsrpc.ProtoHash(msg)
+	msgData, _ := proto.Marshal(msg)
+	rpc := &rpcmsg.Data{
+		Type:     rpcmsg.Data_Broadcast,
+		Msgid:    msgID,
+		Senderid: senderID,
+		Data:     msgData,
+		Attrs:    filter,
+	}
+
+	data, _ := proto.Marshal(rpc)
+	return data
+}
+
+// goodbye不为空时gate先发送给客户端再断开
+func MakeCloseSessionData(sesID int32, goodbye proto.Message, senderID int32) []byte {
+	rpc := &rpcmsg.Data{
+		Type:     rpcmsg.Data_CloseSession,
+		Senderid: senderID,
+		Sesid:    sesID,
+	}
+	if goodbye != nil {
+		rpc.Msgid, _ = natsrpc.ProtoHash(goodbye)
+		rpc.Data, _ = proto.Marshal(goodbye)
+	}
+
+	data, _ := proto.Marshal(rpc)
+	return data
+}
+
+// global为true时发布到所有订阅了session事件的服务器
+func MakeSessionEventData(opened bool, global bool, sesID int32, userID int64, attrs map[string]string, senderID int32) []byte {
+	typ := rpcmsg.Data_SessionClosed
+	if opened {
+		typ = rpcmsg.Data_SessionOpened
+	}
+	rpc := &rpcmsg.Data{
+		Type:     typ,
+		Senderid: senderID,
+		Sesid:    sesID,
+		Userid:   userID,
+		Attrs:    attrs,
+		Global:   global,
+	}
+
+	data, _ := proto.Marshal(rpc)
+	return data
+}
+
+// 发送到session所在的gate
+func MakeSessionRebindData(sesID int32, target int32, senderID int32) []byte {
+	rpc := &rpcmsg.Data{
+		Type:     rpcmsg.Data_SessionRebind,
+		Senderid: senderID,
+		Sesid:    sesID,
+		Target:   target,
 	}
 
 	data, _ := proto.Marshal(rpc)
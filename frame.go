@@ -0,0 +1,221 @@
+package natsrpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+// 帧落后时的处理方式
+type FramePolicy int
+
+const (
+	FrameCatchUp FramePolicy = iota //连续补帧，最多MaxCatchUp帧，保证逻辑帧数与时间一致
+	FrameSkip                       //直接跳到最新的一帧，跳过的帧不执行
+)
+
+const (
+	DefaultFrameRate       = 20
+	DefaultFrameMaxCatchUp = 5
+)
+
+type FrameConfig struct {
+	Rate       int           `json:"rate"`         //每秒帧数，默认20
+	Budget     time.Duration `json:"budget"`       //两帧之间处理消息的最长时间，默认为一帧的间隔，剩余的消息在下一帧之后处理
+	Policy     FramePolicy   `json:"policy"`       //帧落后时的处理方式
+	MaxCatchUp int           `json:"max_catch_up"` //FrameCatchUp一次最多连续补的帧数，超过的帧跳过
+}
+
+type Frame struct {
+	Index uint64        //从1开始的帧序号，跳过的帧也计数
+	Time  time.Time     //该帧计划执行的时间
+	Delta time.Duration //固定的帧间隔
+}
+
+type FrameStat struct {
+	Frames    uint64        //执行的帧数
+	Skipped   uint64        //跳过的帧数
+	CatchUp   uint64        //补执行的帧数
+	Overruns  uint64        //执行时间超过帧间隔的帧数
+	TickTotal time.Duration //帧回调的总执行时间
+	TickMax   time.Duration
+	LagMax    time.Duration //帧实际执行时间相对计划时间的最大延迟
+}
+
+func (p FrameStat) TickAvg() time.Duration {
+	if p.Frames == 0 {
+		return 0
+	}
+	return p.TickTotal / time.Duration(p.Frames)
+}
+
+type frameStats struct {
+	mu   sync.Mutex
+	stat FrameStat
+}
+
+func (p *frameStats) record(tick, lag, step time.Duration, catchUp bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stat.Frames++
+	p.stat.TickTotal += tick
+	if tick > p.stat.TickMax {
+		p.stat.TickMax = tick
+	}
+	if lag > p.stat.LagMax {
+		p.stat.LagMax = lag
+	}
+	if tick > step {
+		p.stat.Overruns++
+	}
+	if catchUp {
+		p.stat.CatchUp++
+	}
+}
+
+func (p *frameStats) skip(n uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stat.Skipped += n
+}
+
+func (p *frameStats) snapshot() FrameStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stat
+}
+
+func (p FrameConfig) withDefault() FrameConfig {
+	if p.Rate <= 0 {
+		p.Rate = DefaultFrameRate
+	}
+	step := time.Second / time.Duration(p.Rate)
+	if p.Budget <= 0 || p.Budget > step {
+		p.Budget = step
+	}
+	if p.MaxCatchUp <= 0 {
+		p.MaxCatchUp = DefaultFrameMaxCatchUp
+	}
+	return p
+}
+
+// 帧循环模式，按照WorkerConfig.Frame的帧率在worker协程中执行tick，两帧之间处理队列中的消息
+// 与Run只能调用其中一个
+func (p *Work) RunFrame(tick func(Frame)) {
+	if !atomic.CompareAndSwapInt32(&p.started, 0, 1) {
+		return
+	}
+	conf := p.conf.Frame.withDefault()
+	start := p.now() //从调用RunFrame时开始计算帧时间
+	go func() {
+		defer close(p.exit)
+		p.frameLoop(conf, start, tick)
+	}()
+}
+
+func (p *Work) FrameStats() FrameStat {
+	return p.frameStats.snapshot()
+}
+
+func (p *Work) frameLoop(conf FrameConfig, start time.Time, tick func(Frame)) {
+	step := time.Second / time.Duration(conf.Rate)
+	timer := time.NewTimer(step)
+	defer timer.Stop()
+
+	var index uint64
+	next := start.Add(step)
+	deadline := start.Add(conf.Budget) //本帧处理消息的截止时间
+	for {
+		select {
+		case <-p.stop:
+			p.drain()
+			return
+		default:
+		}
+
+		// 执行到期的帧
+		if now := p.now(); !now.Before(next) {
+			behind := uint64(now.Sub(next) / step) //除当前帧外落后的帧数
+			run := uint64(1)
+			if conf.Policy == FrameCatchUp {
+				run += behind
+				if run > uint64(conf.MaxCatchUp) {
+					run = uint64(conf.MaxCatchUp)
+				}
+			}
+			if skipped := behind + 1 - run; skipped > 0 {
+				index += skipped
+				next = next.Add(time.Duration(skipped) * step)
+				p.frameStats.skip(skipped)
+			}
+			for i := uint64(0); i < run; i++ {
+				index++
+				p.execFrame(tick, Frame{Index: index, Time: next, Delta: step}, step, i > 0)
+				next = next.Add(step)
+			}
+			deadline = p.now().Add(conf.Budget)
+		}
+		if deadline.After(next) {
+			deadline = next
+		}
+
+		// 两帧之间处理消息，预算用完后剩余的消息留到下一帧之后
+		for p.now().Before(deadline) && !p.stopping() {
+			t, ok := p.next()
+			if !ok {
+				break
+			}
+			p.exec(t)
+		}
+
+		wait := next.Sub(p.now())
+		if wait <= 0 {
+			continue
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		if p.now().Before(deadline) {
+			select {
+			case t := <-p.lanes[laneHigh]:
+				p.exec(t)
+			case t := <-p.lanes[laneNormal]:
+				p.exec(t)
+			case t := <-p.lanes[laneLow]:
+				p.exec(t)
+			case <-timer.C:
+			case <-p.stop:
+				p.drain()
+				return
+			}
+			continue
+		}
+		select {
+		case <-timer.C:
+		case <-p.stop:
+			p.drain()
+			return
+		}
+	}
+}
+
+func (p *Work) execFrame(tick func(Frame), frame Frame, step time.Duration, catchUp bool) {
+	start := p.now()
+	p.protectedFun(func() {
+		tick(frame)
+	})
+	run := p.now().Sub(start)
+	p.frameStats.record(run, start.Sub(frame.Time), step, catchUp)
+	// 超帧一定打印，不受SlowTask影响
+	if run > step {
+		logger.DefaultLogger.Warn("worker frame overrun", zap.Uint64("frame", frame.Index), zap.Duration("run", run), zap.Duration("step", step), zap.Int("workerLen", p.Len()))
+	}
+}
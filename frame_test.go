@@ -0,0 +1,93 @@
+package natsrpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 手动推进的时钟，帧循环只在时钟到达下一帧时执行
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (p *fakeClock) Now() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now
+}
+
+func (p *fakeClock) Add(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.now = p.now.Add(d)
+}
+
+func TestFramePolicy(t *testing.T) {
+	const step = 10 * time.Millisecond
+	for _, policy := range []FramePolicy{FrameCatchUp, FrameSkip} {
+		w := NewWorkerWithConfig(WorkerConfig{Frame: FrameConfig{Rate: 100, Policy: policy, MaxCatchUp: 10}}).(*Work)
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		w.now = clock.Now
+
+		ch := make(chan Frame, 100)
+		var msgs int
+		w.RunFrame(func(f Frame) {
+			// 第3帧执行了4.5帧的时间，之后落后3帧
+			if f.Index == 3 {
+				clock.Add(45 * time.Millisecond)
+			}
+			ch <- f
+		})
+		for i := 0; i < 100; i++ {
+			w.Post(func() { msgs++ })
+		}
+
+		var frames []Frame
+		wait := func(index uint64) {
+			for {
+				select {
+				case f := <-ch:
+					frames = append(frames, f)
+					if f.Index >= index {
+						return
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("policy %d wait frame %d timeout", policy, index)
+				}
+			}
+		}
+		for i := uint64(1); i <= 3; i++ {
+			clock.Add(step)
+			wait(i)
+		}
+		// 第3帧结束时为第7.5帧的时间
+		wait(7)
+		w.Shutdown(context.Background())
+
+		stat := w.FrameStats()
+		if msgs != 100 {
+			t.Errorf("policy %d msgs not equal: %d", policy, msgs)
+		}
+		if stat.Frames != uint64(len(frames)) || stat.Overruns != 1 {
+			t.Errorf("policy %d stat not equal: %+v", policy, stat)
+		}
+		switch policy {
+		case FrameCatchUp:
+			if stat.CatchUp != 3 || stat.Skipped != 0 || len(frames) != 7 {
+				t.Errorf("catch up stat not equal: %+v", stat)
+			}
+		case FrameSkip:
+			if stat.CatchUp != 0 || stat.Skipped != 3 || len(frames) != 4 {
+				t.Errorf("skip stat not equal: %+v", stat)
+			}
+		}
+		// 帧序号连续递增，跳过的帧也计数
+		last := frames[len(frames)-1]
+		if last.Index != stat.Frames+stat.Skipped {
+			t.Errorf("policy %d frame index not equal: %d %+v", policy, last.Index, stat)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package natsrpc
+
+import (
+	"errors"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrGroupName = errors.New("group: invalid name")
+
+// 分组名作为nats subject的一部分，不能为空，不能包含空白和通配符
+func CheckGroupName(group string) error {
+	if group == "" || strings.ContainsAny(group, " \t\r\n*>") {
+		return ErrGroupName
+	}
+	return nil
+}
+
+// 分组在本gate创建和清空时调用，gate用来订阅和取消订阅分组的消息
+// 不持有session锁，可以进行网络调用；同一时间只有一个回调在执行，顺序与分组的变化一致
+func (p *Mgr) RegisterGroupEvent(onCreate, onEmpty func(group string)) {
+	p.onGroupCreate = onCreate
+	p.onGroupEmpty = onEmpty
+}
+
+// session断开时自动离开所有分组，session不存在或分组名不合法时返回false
+func (p *Mgr) JoinGroup(group string, sesID int32) bool {
+	if CheckGroupName(group) != nil {
+		return false
+	}
+	p.groupMutex.Lock()
+	defer p.groupMutex.Unlock()
+
+	p.sesMutex.Lock()
+	c, ok := p.sesID2Client[sesID]
+	if !ok {
+		p.sesMutex.Unlock()
+		return false
+	}
+	members, exist := p.groups[group]
+	if !exist {
+		members = make(map[int32]*Client)
+		p.groups[group] = members
+	}
+	members[sesID] = c
+	if c.groups == nil {
+		c.groups = make(map[string]struct{})
+	}
+	c.groups[group] = struct{}{}
+	p.sesMutex.Unlock()
+
+	if !exist && p.onGroupCreate != nil {
+		p.onGroupCreate(group)
+	}
+	return true
+}
+
+func (p *Mgr) LeaveGroup(group string, sesID int32) {
+	p.groupMutex.Lock()
+	defer p.groupMutex.Unlock()
+
+	p.sesMutex.Lock()
+	if c, ok := p.sesID2Client[sesID]; ok {
+		delete(c.groups, group)
+	}
+	empty := p.leaveGroup(group, sesID)
+	p.sesMutex.Unlock()
+
+	if empty {
+		p.onGroupEmptied(group)
+	}
+}
+
+// 需要持有sesMutex，返回true时分组已清空，释放sesMutex后调用onGroupEmptied
+func (p *Mgr) leaveGroup(group string, sesID int32) bool {
+	members, ok := p.groups[group]
+	if !ok {
+		return false
+	}
+	delete(members, sesID)
+	if len(members) == 0 {
+		delete(p.groups, group)
+		return true
+	}
+	return false
+}
+
+// 需要持有groupMutex
+func (p *Mgr) onGroupEmptied(group string) {
+	if p.onGroupEmpty != nil {
+		p.onGroupEmpty(group)
+	}
+}
+
+// 本gate上的分组成员
+func (p *Mgr) GroupMembers(group string) []Session {
+	p.sesMutex.Lock()
+	defer p.sesMutex.Unlock()
+
+	members := p.groups[group]
+	ret := make([]Session, 0, len(members))
+	for _, v := range members {
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// 发送给本gate上的分组成员，消息只序列化一次
+func (p *Mgr) Multicast(group string, msg proto.Message) error {
+	msgID, _ := ProtoHash(msg)
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.MulticastRaw(group, msgID, data)
+	return nil
+}
+
+func (p *Mgr) MulticastRaw(group string, msgID uint32, data []byte) {
+	buf := p.processor.Encode(msgID, data)
+	for _, s := range p.GroupMembers(group) {
+		s.(*Client).writeRaw(buf)
+	}
+}
@@ -0,0 +1,90 @@
+package natsrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMgrGroup(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	opened := make(chan int32, 3)
+	closed := make(chan int32, 3)
+	mgr.RegisterEvent(func(s Session) { opened <- s.ID() }, func(s Session) { closed <- s.ID() })
+	events := make(chan string, 4)
+	// 回调中不持有session锁，可以访问Mgr
+	mgr.RegisterGroupEvent(func(group string) {
+		if n := len(mgr.GroupMembers(group)); n != 1 {
+			t.Errorf("members on create not equal: %d", n)
+		}
+		events <- "create:" + group
+	}, func(group string) {
+		if n := len(mgr.GroupMembers(group)); n != 0 {
+			t.Errorf("members on empty not equal: %d", n)
+		}
+		events <- "empty:" + group
+	})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&wrapperspb.StringValue{}, nil)
+	conns := make([]net.Conn, 3)
+	ids := make([]int32, 3)
+	for i := range conns {
+		conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conns[i] = conn
+		ids[i] = <-opened
+	}
+
+	if mgr.JoinGroup("bad group", ids[0]) {
+		t.Error("invalid group name joined")
+	}
+	if mgr.JoinGroup("room", 1000) {
+		t.Error("unknown session joined")
+	}
+	mgr.JoinGroup("room", ids[0])
+	mgr.JoinGroup("room", ids[1])
+	if v := <-events; v != "create:room" {
+		t.Errorf("event not equal: %s", v)
+	}
+	if n := len(mgr.GroupMembers("room")); n != 2 {
+		t.Errorf("members not equal: %d", n)
+	}
+
+	mgr.Multicast("room", wrapperspb.String("hi"))
+	for i := 0; i < 2; i++ {
+		msg, err := readTCPMsg(conns[i], client)
+		if err != nil || msg.(*wrapperspb.StringValue).Value != "hi" {
+			t.Fatalf("multicast not equal: %v %v", msg, err)
+		}
+	}
+	conns[2].SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := readTCPMsg(conns[2], client); err == nil {
+		t.Error("non member received")
+	}
+
+	// 离开和断开后分组清空
+	mgr.LeaveGroup("room", ids[0])
+	conns[1].Close()
+	<-closed
+	if v := <-events; v != "empty:room" {
+		t.Errorf("event not equal: %s", v)
+	}
+	if n := len(mgr.GroupMembers("room")); n != 0 {
+		t.Errorf("members not equal: %d", n)
+	}
+}
@@ -0,0 +1,129 @@
+package natsrpc
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+)
+
+type HeartbeatConfig struct {
+	// 服务器发送心跳的间隔，为0时不主动发送，由客户端发送Ping
+	Interval time.Duration `json:"interval"`
+	// 超过该时间没有收到任何消息时断开，为0时为Interval的3倍
+	Timeout time.Duration `json:"timeout"`
+	// websocket使用协议层的ping/pong，客户端不需要处理，其他连接仍使用rpcmsg.Ping
+	WSPing bool `json:"ws_ping"`
+}
+
+const minHeartbeatTick = 10 * time.Millisecond
+
+// 支持协议层心跳的连接
+type pingConn interface {
+	Ping(data []byte) error
+	SetPongHandler(f func(data []byte))
+}
+
+// 开启心跳和空闲超时，需要在Run之前调用
+// 客户端收到rpcmsg.Ping后回复相同time的rpcmsg.Pong，也可以发送Ping，服务器立即回复Pong
+func (p *Mgr) SetHeartbeat(conf HeartbeatConfig) {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3 * conf.Interval
+	}
+	if conf.Timeout <= 0 {
+		return
+	}
+	p.heartbeat = &conf
+	p.processor.RegisterSessionMsgHandler(&rpcmsg.Ping{}, nil)
+	p.processor.RegisterSessionMsgHandler(&rpcmsg.Pong{}, nil)
+}
+
+func (p *Mgr) heartbeatLoop(done chan struct{}) {
+	conf := p.heartbeat
+	tick := conf.Timeout / 4
+	if conf.Interval > 0 && conf.Interval/2 < tick {
+		tick = conf.Interval / 2
+	}
+	if tick < minHeartbeatTick {
+		tick = minHeartbeatTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var clients []*Client
+	lastPing := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		now := time.Now()
+		ping := conf.Interval > 0 && now.Sub(lastPing) >= conf.Interval
+		if ping {
+			lastPing = now
+		}
+
+		clients = clients[:0]
+		p.sesMutex.Lock()
+		for _, c := range p.sesID2Client {
+			clients = append(clients, c)
+		}
+		p.sesMutex.Unlock()
+		for _, c := range clients {
+			if now.Sub(c.LastActive()) > conf.Timeout {
+				c.kick(rpcmsg.Kick_IdleTimeout, "idle timeout")
+				continue
+			}
+			if ping {
+				c.ping(now, conf.WSPing)
+			}
+		}
+	}
+}
+
+func (p *Client) ping(now time.Time, wsPing bool) {
+	if wsPing && p.pinger != nil {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(now.UnixNano()))
+		p.pinger.Ping(buf[:])
+		return
+	}
+	p.SendMsg(&rpcmsg.Ping{Time: now.UnixNano()})
+}
+
+// 心跳消息在读协程中直接处理，不进入worker，返回true时已处理
+func (p *Client) handleHeartbeat(msg proto.Message) bool {
+	switch m := msg.(type) {
+	case *rpcmsg.Ping:
+		p.SendMsg(&rpcmsg.Pong{Time: m.Time})
+		return true
+	case *rpcmsg.Pong:
+		p.onPong(m.Time)
+		return true
+	}
+	return false
+}
+
+func (p *Client) onPong(sendTime int64) {
+	rtt := time.Now().UnixNano() - sendTime
+	if rtt >= 0 {
+		atomic.StoreInt64(&p.rtt, rtt)
+	}
+	p.touch()
+}
+
+func (p *Client) touch() {
+	atomic.StoreInt64(&p.lastActive, time.Now().UnixNano())
+}
+
+// 最后一次收到消息的时间
+func (p *Client) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&p.lastActive))
+}
+
+// 最近一次服务器心跳的往返时间，没有开启心跳时为0
+func (p *Client) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.rtt))
+}
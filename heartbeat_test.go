@@ -0,0 +1,103 @@
+package natsrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+)
+
+func TestMgrHeartbeat(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("127.0.0.1:0", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	mgr.SetHeartbeat(HeartbeatConfig{Interval: 30 * time.Millisecond, Timeout: 150 * time.Millisecond, WSPing: true})
+	opened := make(chan Session, 2)
+	mgr.RegisterEvent(func(s Session) { opened <- s }, func(s Session) {})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&rpcmsg.Ping{}, nil)
+	client.RegisterSessionMsgHandler(&rpcmsg.Pong{}, nil)
+	client.RegisterSessionMsgHandler(&rpcmsg.Kick{}, nil)
+	conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	s := <-opened
+
+	// 客户端发送的Ping立即回复
+	writeTCPMsg(conn, client, &rpcmsg.Ping{Time: 42})
+	for {
+		msg, err := readTCPMsg(conn, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pong, ok := msg.(*rpcmsg.Pong); ok {
+			if pong.Time != 42 {
+				t.Errorf("pong not equal: %d", pong.Time)
+			}
+			break
+		}
+	}
+	// 回复服务器的心跳，超过Timeout也不会断开
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		msg, err := readTCPMsg(conn, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ping, ok := msg.(*rpcmsg.Ping); ok {
+			writeTCPMsg(conn, client, &rpcmsg.Pong{Time: ping.Time})
+		}
+	}
+	if s.RTT() <= 0 {
+		t.Errorf("rtt not measured: %v", s.RTT())
+	}
+	if time.Since(s.LastActive()) > 100*time.Millisecond {
+		t.Errorf("last active not updated: %v", s.LastActive())
+	}
+	// 不再回复后断开
+	for {
+		msg, err := readTCPMsg(conn, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kick, ok := msg.(*rpcmsg.Kick); ok {
+			if kick.Code != rpcmsg.Kick_IdleTimeout {
+				t.Errorf("kick not equal: %v", kick)
+			}
+			break
+		}
+	}
+
+	// websocket使用协议层ping，客户端读取时自动回复pong
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+mgr.ListenAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	s = <-opened
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := mgr.GetSession(s.ID()); !ok {
+		t.Error("ws session closed")
+	}
+	if s.RTT() <= 0 {
+		t.Errorf("ws rtt not measured: %v", s.RTT())
+	}
+}
@@ -0,0 +1,653 @@
+package natsrpc
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// kcp协议的ARQ实现，报文格式与ikcp一致，可以与其他语言的kcp客户端互通
+// 非线程安全，由KCPConn加锁调用
+const (
+	kcpCmdPush = 81 //数据
+	kcpCmdAck  = 82 //确认
+	kcpCmdWask = 83 //询问对端窗口
+	kcpCmdWins = 84 //告知本端窗口
+
+	kcpAskSend = 1
+	kcpAskTell = 2
+
+	kcpOverhead    = 24
+	kcpRtoNoDelay  = 30
+	kcpRtoMin      = 100
+	kcpRtoDef      = 200
+	kcpRtoMax      = 60000
+	kcpWndSnd      = 32
+	kcpWndRcv      = 128
+	kcpMtuDef      = 1400
+	kcpIntervalDef = 100
+	kcpThreshInit  = 2
+	kcpThreshMin   = 2
+	kcpProbeInit   = 7000
+	kcpProbeLimit  = 120000
+	kcpDeadLink    = 20
+)
+
+var (
+	errKCPConv     = errors.New("kcp: conv not match")
+	errKCPData     = errors.New("kcp: invalid data")
+	errKCPEmpty    = errors.New("kcp: empty message")
+	errKCPTooLarge = errors.New("kcp: message too large")
+)
+
+type kcpSegment struct {
+	conv     uint32
+	cmd      uint8
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	resendts uint32
+	rto      uint32
+	fastack  uint32
+	xmit     uint32
+	data     []byte
+}
+
+func (s *kcpSegment) encode(buf []byte) []byte {
+	var head [kcpOverhead]byte
+	binary.LittleEndian.PutUint32(head[0:], s.conv)
+	head[4] = s.cmd
+	head[5] = s.frg
+	binary.LittleEndian.PutUint16(head[6:], s.wnd)
+	binary.LittleEndian.PutUint32(head[8:], s.ts)
+	binary.LittleEndian.PutUint32(head[12:], s.sn)
+	binary.LittleEndian.PutUint32(head[16:], s.una)
+	binary.LittleEndian.PutUint32(head[20:], uint32(len(s.data)))
+	buf = append(buf, head[:]...)
+	return append(buf, s.data...)
+}
+
+type kcpAck struct {
+	sn, ts uint32
+}
+
+type kcp struct {
+	conv, mtu, mss uint32
+	state          int32 //-1表示重传次数过多，连接已断开
+
+	sndUna, sndNxt, rcvNxt uint32
+	ssthresh               uint32
+	rxRttval, rxSrtt       int32
+	rxRto, rxMinrto        uint32
+	sndWnd, rcvWnd, rmtWnd uint32
+	cwnd, incr, probe      uint32
+	current, interval      uint32
+	tsFlush                uint32
+	nodelay, updated       bool
+	tsProbe, probeWait     uint32
+	deadLink               uint32
+	fastresend             uint32
+	nocwnd                 bool
+
+	sndQueue []kcpSegment
+	rcvQueue []kcpSegment
+	sndBuf   []kcpSegment
+	rcvBuf   []kcpSegment
+	acklist  []kcpAck
+
+	buffer []byte
+	output func(data []byte)
+}
+
+func newKCP(conv uint32, output func(data []byte)) *kcp {
+	p := &kcp{
+		conv:     conv,
+		sndWnd:   kcpWndSnd,
+		rcvWnd:   kcpWndRcv,
+		rmtWnd:   kcpWndRcv,
+		mtu:      kcpMtuDef,
+		mss:      kcpMtuDef - kcpOverhead,
+		rxRto:    kcpRtoDef,
+		rxMinrto: kcpRtoMin,
+		interval: kcpIntervalDef,
+		tsFlush:  kcpIntervalDef,
+		ssthresh: kcpThreshInit,
+		deadLink: kcpDeadLink,
+		output:   output,
+	}
+	p.buffer = make([]byte, 0, p.mtu)
+	return p
+}
+
+func timediff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+// nodelay开启时最小rto为30ms且超时后rto按1.5倍增长，resend为快速重传需要跳过的ack数，nc关闭拥塞控制
+func (p *kcp) setNoDelay(nodelay bool, interval, resend int, nc bool) {
+	p.nodelay = nodelay
+	if nodelay {
+		p.rxMinrto = kcpRtoNoDelay
+	} else {
+		p.rxMinrto = kcpRtoMin
+	}
+	if interval > 0 {
+		if interval > 5000 {
+			interval = 5000
+		} else if interval < 10 {
+			interval = 10
+		}
+		p.interval = uint32(interval)
+	}
+	if resend >= 0 {
+		p.fastresend = uint32(resend)
+	}
+	p.nocwnd = nc
+}
+
+func (p *kcp) setWndSize(sndWnd, rcvWnd int) {
+	if sndWnd > 0 {
+		p.sndWnd = uint32(sndWnd)
+	}
+	if rcvWnd > 0 {
+		// 分片数不能超过接收窗口
+		if rcvWnd < kcpWndRcv {
+			rcvWnd = kcpWndRcv
+		}
+		p.rcvWnd = uint32(rcvWnd)
+	}
+}
+
+func (p *kcp) setMtu(mtu int) {
+	if mtu < 50 {
+		return
+	}
+	p.mtu = uint32(mtu)
+	p.mss = p.mtu - kcpOverhead
+	p.buffer = make([]byte, 0, p.mtu)
+}
+
+// 消息按mss分片放入发送队列
+func (p *kcp) send(data []byte) error {
+	if len(data) == 0 {
+		return errKCPEmpty
+	}
+	count := (uint32(len(data)) + p.mss - 1) / p.mss
+	if count >= kcpWndRcv || count > 255 {
+		return errKCPTooLarge
+	}
+	for i := uint32(0); i < count; i++ {
+		size := uint32(len(data))
+		if size > p.mss {
+			size = p.mss
+		}
+		seg := kcpSegment{data: make([]byte, size), frg: uint8(count - i - 1)}
+		copy(seg.data, data[:size])
+		p.sndQueue = append(p.sndQueue, seg)
+		data = data[size:]
+	}
+	return nil
+}
+
+// 下一条完整消息的长度，没有时返回-1
+func (p *kcp) peekSize() int {
+	if len(p.rcvQueue) == 0 {
+		return -1
+	}
+	seg := &p.rcvQueue[0]
+	if seg.frg == 0 {
+		return len(seg.data)
+	}
+	if len(p.rcvQueue) < int(seg.frg)+1 {
+		return -1
+	}
+	length := 0
+	for i := range p.rcvQueue {
+		length += len(p.rcvQueue[i].data)
+		if p.rcvQueue[i].frg == 0 {
+			break
+		}
+	}
+	return length
+}
+
+// 取出一条完整的消息
+func (p *kcp) recv() ([]byte, bool) {
+	size := p.peekSize()
+	if size < 0 {
+		return nil, false
+	}
+	full := len(p.rcvQueue) >= int(p.rcvWnd)
+
+	data := make([]byte, 0, size)
+	n := 0
+	for i := range p.rcvQueue {
+		seg := &p.rcvQueue[i]
+		data = append(data, seg.data...)
+		n++
+		if seg.frg == 0 {
+			break
+		}
+	}
+	p.rcvQueue = removeFront(p.rcvQueue, n)
+	p.moveRcvBuf()
+
+	// 接收窗口从满变为有空位，通知对端
+	if full && len(p.rcvQueue) < int(p.rcvWnd) {
+		p.probe |= kcpAskTell
+	}
+	return data, true
+}
+
+func (p *kcp) moveRcvBuf() {
+	n := 0
+	for i := range p.rcvBuf {
+		seg := &p.rcvBuf[i]
+		if seg.sn != p.rcvNxt || len(p.rcvQueue) >= int(p.rcvWnd) {
+			break
+		}
+		p.rcvQueue = append(p.rcvQueue, *seg)
+		p.rcvNxt++
+		n++
+	}
+	p.rcvBuf = removeFront(p.rcvBuf, n)
+}
+
+func removeFront(q []kcpSegment, n int) []kcpSegment {
+	if n == 0 {
+		return q
+	}
+	copy(q, q[n:])
+	for i := len(q) - n; i < len(q); i++ {
+		q[i] = kcpSegment{}
+	}
+	return q[:len(q)-n]
+}
+
+func (p *kcp) updateAck(rtt int32) {
+	if p.rxSrtt == 0 {
+		p.rxSrtt = rtt
+		p.rxRttval = rtt / 2
+	} else {
+		delta := rtt - p.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		p.rxRttval = (3*p.rxRttval + delta) / 4
+		p.rxSrtt = (7*p.rxSrtt + rtt) / 8
+		if p.rxSrtt < 1 {
+			p.rxSrtt = 1
+		}
+	}
+	rto := uint32(p.rxSrtt) + maxU32(p.interval, uint32(4*p.rxRttval))
+	p.rxRto = boundU32(p.rxMinrto, rto, kcpRtoMax)
+}
+
+func (p *kcp) shrinkBuf() {
+	if len(p.sndBuf) > 0 {
+		p.sndUna = p.sndBuf[0].sn
+	} else {
+		p.sndUna = p.sndNxt
+	}
+}
+
+func (p *kcp) parseAck(sn uint32) {
+	if timediff(sn, p.sndUna) < 0 || timediff(sn, p.sndNxt) >= 0 {
+		return
+	}
+	for i := range p.sndBuf {
+		seg := &p.sndBuf[i]
+		if sn == seg.sn {
+			copy(p.sndBuf[i:], p.sndBuf[i+1:])
+			p.sndBuf[len(p.sndBuf)-1] = kcpSegment{}
+			p.sndBuf = p.sndBuf[:len(p.sndBuf)-1]
+			break
+		}
+		if timediff(sn, seg.sn) < 0 {
+			break
+		}
+	}
+}
+
+func (p *kcp) parseFastack(sn, ts uint32) {
+	if timediff(sn, p.sndUna) < 0 || timediff(sn, p.sndNxt) >= 0 {
+		return
+	}
+	for i := range p.sndBuf {
+		seg := &p.sndBuf[i]
+		if timediff(sn, seg.sn) < 0 {
+			break
+		}
+		if sn != seg.sn && timediff(seg.ts, ts) <= 0 {
+			seg.fastack++
+		}
+	}
+}
+
+func (p *kcp) parseUna(una uint32) {
+	n := 0
+	for i := range p.sndBuf {
+		if timediff(una, p.sndBuf[i].sn) > 0 {
+			n++
+		} else {
+			break
+		}
+	}
+	p.sndBuf = removeFront(p.sndBuf, n)
+}
+
+func (p *kcp) parseData(seg kcpSegment) {
+	sn := seg.sn
+	if timediff(sn, p.rcvNxt+p.rcvWnd) >= 0 || timediff(sn, p.rcvNxt) < 0 {
+		return
+	}
+
+	// 按sn有序插入，重复的丢弃
+	i := len(p.rcvBuf) - 1
+	for ; i >= 0; i-- {
+		if p.rcvBuf[i].sn == sn {
+			return
+		}
+		if timediff(sn, p.rcvBuf[i].sn) > 0 {
+			break
+		}
+	}
+	p.rcvBuf = append(p.rcvBuf, kcpSegment{})
+	copy(p.rcvBuf[i+2:], p.rcvBuf[i+1:])
+	p.rcvBuf[i+1] = seg
+
+	p.moveRcvBuf()
+}
+
+// 处理收到的udp包
+func (p *kcp) input(data []byte) error {
+	prevUna := p.sndUna
+	var maxack, latestTs uint32
+	flag := false
+
+	if len(data) < kcpOverhead {
+		return errKCPData
+	}
+	for len(data) >= kcpOverhead {
+		conv := binary.LittleEndian.Uint32(data)
+		if conv != p.conv {
+			return errKCPConv
+		}
+		cmd := data[4]
+		frg := data[5]
+		wnd := binary.LittleEndian.Uint16(data[6:])
+		ts := binary.LittleEndian.Uint32(data[8:])
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[kcpOverhead:]
+		if uint32(len(data)) < length {
+			return errKCPData
+		}
+		if cmd != kcpCmdPush && cmd != kcpCmdAck && cmd != kcpCmdWask && cmd != kcpCmdWins {
+			return errKCPData
+		}
+
+		p.rmtWnd = uint32(wnd)
+		p.parseUna(una)
+		p.shrinkBuf()
+
+		switch cmd {
+		case kcpCmdAck:
+			if timediff(p.current, ts) >= 0 {
+				p.updateAck(timediff(p.current, ts))
+			}
+			p.parseAck(sn)
+			p.shrinkBuf()
+			if !flag {
+				flag = true
+				maxack, latestTs = sn, ts
+			} else if timediff(sn, maxack) > 0 {
+				maxack, latestTs = sn, ts
+			}
+		case kcpCmdPush:
+			if timediff(sn, p.rcvNxt+p.rcvWnd) < 0 {
+				p.acklist = append(p.acklist, kcpAck{sn: sn, ts: ts})
+				if timediff(sn, p.rcvNxt) >= 0 {
+					seg := kcpSegment{conv: conv, cmd: cmd, frg: frg, wnd: wnd, ts: ts, sn: sn, una: una}
+					seg.data = make([]byte, length)
+					copy(seg.data, data[:length])
+					p.parseData(seg)
+				}
+			}
+		case kcpCmdWask:
+			p.probe |= kcpAskTell
+		case kcpCmdWins:
+		}
+		data = data[length:]
+	}
+
+	if flag {
+		p.parseFastack(maxack, latestTs)
+	}
+
+	// 有新的确认时增大拥塞窗口
+	if timediff(p.sndUna, prevUna) > 0 && p.cwnd < p.rmtWnd {
+		mss := p.mss
+		if p.cwnd < p.ssthresh {
+			p.cwnd++
+			p.incr += mss
+		} else {
+			if p.incr < mss {
+				p.incr = mss
+			}
+			p.incr += (mss*mss)/p.incr + mss/16
+			if (p.cwnd+1)*mss <= p.incr {
+				p.cwnd = (p.incr + mss - 1) / mss
+			}
+		}
+		if p.cwnd > p.rmtWnd {
+			p.cwnd = p.rmtWnd
+			p.incr = p.rmtWnd * mss
+		}
+	}
+	return nil
+}
+
+func (p *kcp) wndUnused() uint16 {
+	if len(p.rcvQueue) < int(p.rcvWnd) {
+		return uint16(int(p.rcvWnd) - len(p.rcvQueue))
+	}
+	return 0
+}
+
+// 按mtu合并输出
+func (p *kcp) write(seg *kcpSegment) {
+	if len(p.buffer)+kcpOverhead+len(seg.data) > int(p.mtu) {
+		p.output(p.buffer)
+		p.buffer = p.buffer[:0]
+	}
+	p.buffer = seg.encode(p.buffer)
+}
+
+func (p *kcp) flush() {
+	if !p.updated {
+		return
+	}
+	current := p.current
+
+	seg := kcpSegment{conv: p.conv, cmd: kcpCmdAck, wnd: p.wndUnused(), una: p.rcvNxt}
+	for _, ack := range p.acklist {
+		seg.sn, seg.ts = ack.sn, ack.ts
+		p.write(&seg)
+	}
+	p.acklist = p.acklist[:0]
+
+	// 对端窗口为0时定期询问
+	if p.rmtWnd == 0 {
+		if p.probeWait == 0 {
+			p.probeWait = kcpProbeInit
+			p.tsProbe = current + p.probeWait
+		} else if timediff(current, p.tsProbe) >= 0 {
+			if p.probeWait < kcpProbeInit {
+				p.probeWait = kcpProbeInit
+			}
+			p.probeWait += p.probeWait / 2
+			if p.probeWait > kcpProbeLimit {
+				p.probeWait = kcpProbeLimit
+			}
+			p.tsProbe = current + p.probeWait
+			p.probe |= kcpAskSend
+		}
+	} else {
+		p.tsProbe = 0
+		p.probeWait = 0
+	}
+	if p.probe&kcpAskSend != 0 {
+		seg.cmd = kcpCmdWask
+		p.write(&seg)
+	}
+	if p.probe&kcpAskTell != 0 {
+		seg.cmd = kcpCmdWins
+		p.write(&seg)
+	}
+	p.probe = 0
+
+	cwnd := minU32(p.sndWnd, p.rmtWnd)
+	if !p.nocwnd {
+		cwnd = minU32(p.cwnd, cwnd)
+	}
+
+	// 发送队列移到发送缓冲
+	n := 0
+	for n < len(p.sndQueue) && timediff(p.sndNxt, p.sndUna+cwnd) < 0 {
+		newseg := p.sndQueue[n]
+		newseg.conv = p.conv
+		newseg.cmd = kcpCmdPush
+		newseg.ts = current
+		newseg.sn = p.sndNxt
+		newseg.una = p.rcvNxt
+		newseg.resendts = current
+		newseg.rto = p.rxRto
+		p.sndBuf = append(p.sndBuf, newseg)
+		p.sndNxt++
+		n++
+	}
+	p.sndQueue = removeFront(p.sndQueue, n)
+
+	resent := p.fastresend
+	if resent == 0 {
+		resent = 0xffffffff
+	}
+	rtomin := p.rxRto >> 3
+	if p.nodelay {
+		rtomin = 0
+	}
+
+	lost, change := false, false
+	for i := range p.sndBuf {
+		segment := &p.sndBuf[i]
+		needsend := false
+		if segment.xmit == 0 {
+			needsend = true
+			segment.xmit++
+			segment.rto = p.rxRto
+			segment.resendts = current + segment.rto + rtomin
+		} else if timediff(current, segment.resendts) >= 0 {
+			// 超时重传
+			needsend = true
+			segment.xmit++
+			if !p.nodelay {
+				segment.rto += maxU32(segment.rto, p.rxRto)
+			} else {
+				segment.rto += segment.rto / 2
+			}
+			segment.resendts = current + segment.rto
+			lost = true
+		} else if segment.fastack >= resent {
+			// 快速重传
+			needsend = true
+			segment.xmit++
+			segment.fastack = 0
+			segment.resendts = current + segment.rto
+			change = true
+		}
+
+		if needsend {
+			segment.ts = current
+			segment.wnd = seg.wnd
+			segment.una = p.rcvNxt
+			p.write(segment)
+			if segment.xmit >= p.deadLink {
+				p.state = -1
+			}
+		}
+	}
+
+	if len(p.buffer) > 0 {
+		p.output(p.buffer)
+		p.buffer = p.buffer[:0]
+	}
+
+	if change {
+		inflight := p.sndNxt - p.sndUna
+		p.ssthresh = inflight / 2
+		if p.ssthresh < kcpThreshMin {
+			p.ssthresh = kcpThreshMin
+		}
+		p.cwnd = p.ssthresh + resent
+		p.incr = p.cwnd * p.mss
+	}
+	if lost {
+		p.ssthresh = cwnd / 2
+		if p.ssthresh < kcpThreshMin {
+			p.ssthresh = kcpThreshMin
+		}
+		p.cwnd = 1
+		p.incr = p.mss
+	}
+	if p.cwnd < 1 {
+		p.cwnd = 1
+		p.incr = p.mss
+	}
+}
+
+// current为毫秒时间戳，按interval调用flush
+func (p *kcp) update(current uint32) {
+	p.current = current
+	if !p.updated {
+		p.updated = true
+		p.tsFlush = current
+	}
+	slap := timediff(current, p.tsFlush)
+	if slap >= 10000 || slap < -10000 {
+		p.tsFlush = current
+		slap = 0
+	}
+	if slap >= 0 {
+		p.tsFlush += p.interval
+		if timediff(current, p.tsFlush) >= 0 {
+			p.tsFlush = current + p.interval
+		}
+		p.flush()
+	}
+}
+
+// 等待发送的分片数
+func (p *kcp) waitSnd() int {
+	return len(p.sndBuf) + len(p.sndQueue)
+}
+
+func minU32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxU32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func boundU32(lower, middle, upper uint32) uint32 {
+	return minU32(maxU32(lower, middle), upper)
+}
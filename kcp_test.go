@@ -0,0 +1,271 @@
+package natsrpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 丢包、乱序的链路上消息仍然按顺序完整到达
+func TestKCPLossyLink(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	type packet struct {
+		at   uint32
+		data []byte
+	}
+	var toB, toA []packet
+	var now uint32
+	link := func(q *[]packet) func([]byte) {
+		return func(data []byte) {
+			if r.Intn(100) < 20 {
+				return
+			}
+			*q = append(*q, packet{at: now + uint32(10+r.Intn(30)), data: append([]byte(nil), data...)})
+		}
+	}
+	a := newKCP(1, link(&toB))
+	b := newKCP(1, link(&toA))
+	for _, k := range []*kcp{a, b} {
+		k.setNoDelay(true, 10, 2, true)
+		k.setWndSize(128, 128)
+	}
+
+	var expect [][]byte
+	for i := 0; i < 200; i++ {
+		msg := []byte(fmt.Sprintf("msg-%d", i))
+		if i%50 == 0 {
+			msg = bytes.Repeat(msg, 1000) //多个分片
+		}
+		expect = append(expect, msg)
+		if err := a.send(msg); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	deliver := func(q *[]packet, k *kcp) {
+		rest := (*q)[:0]
+		for _, v := range *q {
+			if v.at <= now {
+				k.input(v.data)
+			} else {
+				rest = append(rest, v)
+			}
+		}
+		*q = rest
+	}
+	var got [][]byte
+	for ; now < 60000 && len(got) < len(expect); now += 5 {
+		a.update(now)
+		b.update(now)
+		deliver(&toB, b)
+		deliver(&toA, a)
+		for {
+			data, ok := b.recv()
+			if !ok {
+				break
+			}
+			got = append(got, data)
+		}
+	}
+	if len(got) != len(expect) {
+		t.Errorf("recv count not equal: %d", len(got))
+		return
+	}
+	for i := range expect {
+		if !bytes.Equal(got[i], expect[i]) {
+			t.Errorf("msg %d not equal", i)
+			return
+		}
+	}
+}
+
+func TestMgrKCP(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	conf := FastKCPConfig()
+	mgr.ListenKCP("127.0.0.1:0", conf)
+	closed := make(chan int32, 1)
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) { closed <- s.ID() })
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.StringValue) {
+		s.SendMsg(wrapperspb.String(msg.Value + " world"))
+	})
+	mgr.Run()
+	defer mgr.Close()
+
+	conn, err := DialKCP(mgr.KCPListenAddrs()[0].String(), conf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		data, _ := mgr.processor.Marshal(wrapperspb.String("hello"))
+		if err := conn.WriteMsg(data); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for i := 0; i < 10; i++ {
+		data, err := conn.ReadMsg()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msg, err := mgr.processor.Unmarshal(data)
+		if err != nil || msg.(*wrapperspb.StringValue).Value != "hello world" {
+			t.Errorf("resp not equal: %v %v", msg, err)
+			return
+		}
+	}
+
+	// 服务器关闭session
+	if s, ok := mgr.GetSession(1); ok {
+		s.Close()
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("session not closed")
+	}
+}
+
+// 关闭后继续重传未确认的数据，告别消息不会因为丢包丢失
+func TestKCPCloseFlush(t *testing.T) {
+	conf := FastKCPConfig().withDefault()
+	var a, b *KCPConn
+	dropped := false
+	a = newKCPConn(1, conf, nil, nil, func(data []byte) {
+		if !dropped {
+			dropped = true
+			return
+		}
+		b.input(append([]byte(nil), data...))
+	})
+	b = newKCPConn(1, conf, nil, nil, func(data []byte) {
+		a.input(append([]byte(nil), data...))
+	})
+
+	a.WriteMsg([]byte("goodbye"))
+	a.Close()
+	if err := a.WriteMsg([]byte("late")); err != ErrConnClosed {
+		t.Errorf("write after close: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		a.update()
+		b.update()
+		select {
+		case <-a.gone:
+			data, err := b.ReadMsg()
+			if err != nil || string(data) != "goodbye" {
+				t.Errorf("goodbye not equal: %q %v", data, err)
+			}
+			return
+		default:
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("unacked data not released")
+}
+
+type kcpTestInvoker struct {
+	conn   Conn
+	msgs   chan string
+	closed chan struct{}
+}
+
+func (p *kcpTestInvoker) OnNew() {
+	for {
+		data, err := p.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		p.msgs <- string(data)
+	}
+}
+
+func (p *kcpTestInvoker) OnClose() {
+	close(p.closed)
+}
+
+// 相同地址使用新的conv重新连接时替换旧连接，超过最大连接数时忽略新地址
+func TestKCPServerReconnect(t *testing.T) {
+	conf := FastKCPConfig()
+	conf.MaxSessions = 1
+	invokers := make(chan *kcpTestInvoker, 4)
+	svr := NewKCPServer("127.0.0.1:0", conf, func(conn Conn) ConnInvoker {
+		v := &kcpTestInvoker{conn: conn, msgs: make(chan string, 4), closed: make(chan struct{})}
+		invokers <- v
+		return v
+	})
+	if err := svr.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	udp, err := net.DialUDP("udp", nil, svr.ListenAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	send := func(conv uint32, msg string) {
+		c := newKCPConn(conv, conf.withDefault(), nil, nil, func(data []byte) {
+			udp.Write(data)
+		})
+		c.update()
+		c.WriteMsg([]byte(msg))
+	}
+	recv := func(v *kcpTestInvoker) string {
+		select {
+		case msg := <-v.msgs:
+			return msg
+		case <-time.After(time.Second):
+			return ""
+		}
+	}
+
+	send(1, "first")
+	v1 := <-invokers
+	if msg := recv(v1); msg != "first" {
+		t.Fatalf("first not equal: %q", msg)
+	}
+
+	// 其他地址超过最大连接数
+	other, err := net.DialUDP("udp", nil, svr.ListenAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	c := newKCPConn(3, conf.withDefault(), nil, nil, func(data []byte) {
+		other.Write(data)
+	})
+	c.update()
+	c.WriteMsg([]byte("other"))
+
+	send(2, "second")
+	select {
+	case <-v1.closed:
+	case <-time.After(time.Second):
+		t.Fatal("old session not replaced")
+	}
+	v2 := <-invokers
+	if msg := recv(v2); msg != "second" {
+		t.Fatalf("second not equal: %q", msg)
+	}
+	select {
+	case v := <-invokers:
+		t.Errorf("session over limit created: %d", v.conn.ID())
+	default:
+	}
+}
@@ -0,0 +1,452 @@
+package natsrpc
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+type KCPConfig struct {
+	NoDelay      bool          `json:"nodelay"`       //最小rto 30ms，超时后rto按1.5倍增长
+	Interval     int           `json:"interval"`      //内部刷新间隔，单位ms，默认10
+	Resend       int           `json:"resend"`        //跳过多少个ack后快速重传，0关闭
+	NoCongestion bool          `json:"no_congestion"` //关闭拥塞控制
+	SndWnd       int           `json:"snd_wnd"`       //发送窗口，单位为分片，默认128
+	RcvWnd       int           `json:"rcv_wnd"`       //接收窗口，默认128
+	MTU          int           `json:"mtu"`           //默认1400
+	Timeout      time.Duration `json:"timeout"`       //超过该时间没有收到数据时断开，默认30s
+	MaxSessions  int           `json:"max_sessions"`  //服务器最大连接数，超过时忽略新连接的数据，默认10000
+}
+
+// 实时对战使用的低延迟配置，同kcp的极速模式
+func FastKCPConfig() KCPConfig {
+	return KCPConfig{NoDelay: true, Interval: 10, Resend: 2, NoCongestion: true}
+}
+
+const (
+	kcpDefaultInterval = 10
+	kcpDefaultWnd      = 128
+	kcpDefaultTimeout  = 30 * time.Second
+	kcpDefaultSessions = 10000
+	kcpSendLimit       = 4               //等待发送的分片超过发送窗口的倍数时拒绝发送
+	kcpLinger          = 2 * time.Second //关闭后继续重传未确认数据的最长时间
+)
+
+func (p KCPConfig) withDefault() KCPConfig {
+	if p.Interval <= 0 {
+		p.Interval = kcpDefaultInterval
+	}
+	if p.SndWnd <= 0 {
+		p.SndWnd = kcpDefaultWnd
+	}
+	if p.RcvWnd <= 0 {
+		p.RcvWnd = kcpDefaultWnd
+	}
+	if p.MTU <= 0 {
+		p.MTU = kcpMtuDef
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = kcpDefaultTimeout
+	}
+	if p.MaxSessions <= 0 {
+		p.MaxSessions = kcpDefaultSessions
+	}
+	return p
+}
+
+// 可靠udp连接，每个udp包可以包含多个kcp分片，一条消息为4字节msgID+protobuf，不需要额外的长度
+// kcp没有断开通知，对端断开后在Timeout后关闭
+type KCPConn struct {
+	conf   KCPConfig
+	kcp    *kcp
+	id     int32
+	local  net.Addr
+	remote net.Addr
+	start  time.Time
+
+	mu          sync.Mutex
+	lastRecv    time.Time
+	lingerUntil time.Time
+	readable    chan struct{}
+	die         chan struct{} //Close后关闭，不能再读写
+	gone        chan struct{} //未确认的数据发送完或超时后关闭，之后不再刷新
+	once        sync.Once
+	goneOnce    sync.Once
+	onClose     func()
+}
+
+func newKCPConn(conv uint32, conf KCPConfig, local, remote net.Addr, output func([]byte)) *KCPConn {
+	p := &KCPConn{
+		conf:     conf,
+		local:    local,
+		remote:   remote,
+		start:    time.Now(),
+		lastRecv: time.Now(),
+		readable: make(chan struct{}, 1),
+		die:      make(chan struct{}),
+		gone:     make(chan struct{}),
+	}
+	p.kcp = newKCP(conv, output)
+	p.kcp.setNoDelay(conf.NoDelay, conf.Interval, conf.Resend, conf.NoCongestion)
+	p.kcp.setWndSize(conf.SndWnd, conf.RcvWnd)
+	p.kcp.setMtu(conf.MTU)
+	return p
+}
+
+func (p *KCPConn) now() uint32 {
+	return uint32(time.Since(p.start) / time.Millisecond)
+}
+
+func (p *KCPConn) input(data []byte) {
+	p.mu.Lock()
+	p.lastRecv = time.Now()
+	p.kcp.current = p.now()
+	err := p.kcp.input(data)
+	ready := p.kcp.peekSize() >= 0
+	p.mu.Unlock()
+
+	if err != nil {
+		logger.DefaultLogger.Warn("kcp input", zap.String("remote", p.remote.String()), zap.Error(err))
+	}
+	if ready {
+		select {
+		case p.readable <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 定时刷新，重传次数过多或超时没有收到数据时关闭
+// 关闭后继续重传未确认的数据，全部确认或超过kcpLinger后释放
+func (p *KCPConn) update() {
+	p.mu.Lock()
+	p.kcp.update(p.now())
+	dead := p.kcp.state < 0 || time.Since(p.lastRecv) > p.conf.Timeout
+	closing := !p.lingerUntil.IsZero()
+	sent := p.kcp.waitSnd() == 0 || time.Now().After(p.lingerUntil)
+	p.mu.Unlock()
+	if closing && (dead || sent) {
+		p.release()
+	} else if dead {
+		p.abort()
+	}
+}
+
+func (p *KCPConn) ReadMsg() ([]byte, error) {
+	for {
+		p.mu.Lock()
+		data, ok := p.kcp.recv()
+		p.mu.Unlock()
+		if ok {
+			return data, nil
+		}
+		select {
+		case <-p.readable:
+		case <-p.die:
+			return nil, ErrConnClosed
+		}
+	}
+}
+
+// 写入后立即发送，不等待下次刷新
+func (p *KCPConn) WriteMsg(data []byte) error {
+	select {
+	case <-p.die:
+		return ErrConnClosed
+	default:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.kcp.waitSnd() >= kcpSendLimit*p.conf.SndWnd {
+		return ErrConnSendFull
+	}
+	if err := p.kcp.send(data); err != nil {
+		return err
+	}
+	p.kcp.current = p.now()
+	p.kcp.flush()
+	return nil
+}
+
+func (p *KCPConn) LocalAddr() net.Addr {
+	return p.local
+}
+
+func (p *KCPConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+func (p *KCPConn) ID() int32 {
+	return p.id
+}
+
+// 不能再读写，已经写入的消息继续发送，对端确认或超过kcpLinger后释放
+func (p *KCPConn) Close() {
+	p.once.Do(func() {
+		close(p.die)
+		p.mu.Lock()
+		p.kcp.current = p.now()
+		p.kcp.flush()
+		pending := p.kcp.waitSnd() > 0 && p.kcp.state >= 0
+		if pending {
+			p.lingerUntil = time.Now().Add(kcpLinger)
+		}
+		p.mu.Unlock()
+		if !pending {
+			p.release()
+		}
+	})
+}
+
+// 立即关闭，未确认的数据不再重传
+func (p *KCPConn) abort() {
+	p.once.Do(func() {
+		close(p.die)
+	})
+	p.release()
+}
+
+func (p *KCPConn) release() {
+	p.goneOnce.Do(func() {
+		close(p.gone)
+		if p.onClose != nil {
+			p.onClose()
+		}
+	})
+}
+
+// 监听udp端口，按对端地址区分连接，客户端发送第一条消息时建立连接
+type KCPServer struct {
+	addr      string
+	conf      KCPConfig
+	onInvoker func(conn Conn) ConnInvoker
+
+	connid int32
+
+	mu       sync.Mutex
+	conn     *net.UDPConn
+	sessions map[string]*KCPConn
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewKCPServer(addr string, conf KCPConfig, invoker func(conn Conn) ConnInvoker) *KCPServer {
+	return &KCPServer{
+		addr:      addr,
+		conf:      conf.withDefault(),
+		onInvoker: invoker,
+		sessions:  make(map[string]*KCPConn),
+		done:      make(chan struct{}),
+	}
+}
+
+func (p *KCPServer) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", p.addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		logger.DefaultLogger.Error("KCPServer listen failed", zap.String("addr", p.addr), zap.Error(err))
+		return err
+	}
+	p.conn = conn
+	go p.readLoop()
+	go p.updateLoop()
+	return nil
+}
+
+func (p *KCPServer) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < kcpOverhead {
+			continue
+		}
+		data := buf[:n]
+
+		key := addr.String()
+		conv := binary.LittleEndian.Uint32(data)
+		var old *KCPConn
+		p.mu.Lock()
+		sess, ok := p.sessions[key]
+		if ok && sess.kcp.conv != conv {
+			// 客户端从相同的地址重新连接，替换旧的连接，其他conv不同的包直接忽略
+			if !kcpFirstPush(data) {
+				p.mu.Unlock()
+				continue
+			}
+			old = sess
+			delete(p.sessions, key)
+			ok = false
+		}
+		if !ok {
+			if p.closed || !kcpFirstPush(data) || len(p.sessions) >= p.conf.MaxSessions {
+				p.mu.Unlock()
+				if old != nil {
+					old.abort()
+				}
+				continue
+			}
+			sess = p.newSession(conv, addr)
+		}
+		p.mu.Unlock()
+		if old != nil {
+			old.abort()
+		}
+		sess.input(data)
+	}
+}
+
+// 新连接的第一个包必须是sn为0的数据，避免服务器关闭连接后对端的重传包再次建立连接
+func kcpFirstPush(data []byte) bool {
+	return data[4] == kcpCmdPush && binary.LittleEndian.Uint32(data[12:]) == 0
+}
+
+// 需要持有mu
+func (p *KCPServer) newSession(conv uint32, addr *net.UDPAddr) *KCPConn {
+	key := addr.String()
+	sess := newKCPConn(conv, p.conf, p.conn.LocalAddr(), addr, func(data []byte) {
+		p.conn.WriteToUDP(data, addr)
+	})
+	sess.id = atomic.AddInt32(&p.connid, 1)
+	sess.onClose = func() {
+		p.mu.Lock()
+		if p.sessions[key] == sess {
+			delete(p.sessions, key)
+		}
+		p.mu.Unlock()
+	}
+	p.sessions[key] = sess
+	p.wg.Add(1)
+	go p.handle(sess)
+	return sess
+}
+
+func (p *KCPServer) handle(conn *KCPConn) {
+	defer p.wg.Done()
+
+	var invoker ConnInvoker
+	if p.onInvoker != nil {
+		invoker = p.onInvoker(conn)
+	}
+	if invoker != nil {
+		invoker.OnNew()
+	}
+	conn.Close()
+	if invoker != nil {
+		invoker.OnClose()
+	}
+}
+
+func (p *KCPServer) updateLoop() {
+	ticker := time.NewTicker(time.Duration(p.conf.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	var sessions []*KCPConn
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+		sessions = sessions[:0]
+		p.mu.Lock()
+		for _, v := range p.sessions {
+			sessions = append(sessions, v)
+		}
+		p.mu.Unlock()
+		for _, v := range sessions {
+			v.update()
+		}
+	}
+}
+
+// 停止监听并断开所有连接
+func (p *KCPServer) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	sessions := make([]*KCPConn, 0, len(p.sessions))
+	for _, v := range p.sessions {
+		sessions = append(sessions, v)
+	}
+	p.mu.Unlock()
+
+	for _, v := range sessions {
+		v.abort()
+	}
+	p.wg.Wait()
+}
+
+func (p *KCPServer) ListenAddr() *net.UDPAddr {
+	return p.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// 客户端连接，用于go客户端和测试
+func DialKCP(addr string, conf KCPConfig) (*KCPConn, error) {
+	conf = conf.withDefault()
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newKCPConn(rand.Uint32(), conf, conn.LocalAddr(), udpAddr, func(data []byte) {
+		conn.Write(data)
+	})
+	p.onClose = func() {
+		conn.Close()
+	}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				select {
+				case <-p.gone:
+					return
+				default:
+				}
+				// 服务器还没有启动时会收到icmp不可达，等待重传
+				continue
+			}
+			if n >= kcpOverhead {
+				p.input(buf[:n])
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(time.Duration(conf.Interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.update()
+			case <-p.gone:
+				return
+			}
+		}
+	}()
+	return p, nil
+}
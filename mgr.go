@@ -1,10 +1,13 @@
 package natsrpc
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"encoding/binary"
 	"fmt"
@@ -12,7 +15,7 @@ import (
 	"hash/crc32"
 
 	"github.com/wwqdrh/gokit/logger"
-	"github.com/wwqdrh/gokit/nettool/server/ws"
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -34,22 +37,46 @@ func CRC32Hash(s string) uint32 {
 
 type Mgr struct {
 	wsAddr         string
+	tcpAddrs       []string
+	kcpAddrs       map[string]KCPConfig
 	sesID2Client   map[int32]*Client
+	user2Client    map[int64]*Client            //sesMutex保护
+	groups         map[string]map[int32]*Client //sesMutex保护
+	onGroupCreate  func(group string)
+	onGroupEmpty   func(group string)
+	broadcastRate  int
+	broadcasts     chan broadcastJob
+	broadcastDrops int64 //原子操作
 	sesMutex       sync.Mutex
+	groupMutex     sync.Mutex //在sesMutex之前加锁，保证分组回调的顺序
+	sesID          int32      //所有监听共用，保证不同传输方式的session id不重复
 	onNew, onClose func(conn Session)
 	processor      *Processor
 	worker         Worker
-	wss            *ws.WSServer
+	wss            *WSServer
+	tlsConf        *TLSConfig
+	certReloader   *CertReloader
+	tcps           []*TCPServer
+	kcps           []*KCPServer
+	auth           AuthHandler
+	authTimeout    time.Duration
+	heartbeat      *HeartbeatConfig
+	sessionLimit   *SessionLimitConfig
+	msgRateLimits  map[uint32]RateLimit
 
 	close func()
 }
 
 func NewMgr(wsAddr string, worker Worker) *Mgr {
 	p := &Mgr{
-		wsAddr:       wsAddr,
-		sesID2Client: make(map[int32]*Client),
-		worker:       worker,
-		processor:    NewProcessor(),
+		wsAddr:        wsAddr,
+		sesID2Client:  make(map[int32]*Client),
+		user2Client:   make(map[int64]*Client),
+		groups:        make(map[string]map[int32]*Client),
+		broadcastRate: DefaultBroadcastRate,
+		broadcasts:    make(chan broadcastJob, broadcastQueue),
+		worker:        worker,
+		processor:     NewProcessor(),
 	}
 	return p
 }
@@ -83,27 +110,227 @@ func CheckArgs1MsgFun(cb interface{}) (err error, funValue reflect.Value, msgTyp
 //	p.worker = worker
 //}
 
-func (p *Mgr) Run() {
-	wss := ws.NewWSServer(p.wsAddr, func(conn ws.Conn) ws.IConnInvoker {
-		return NewClient(conn, p)
-	})
-	p.wss = wss
-	wss.Start()
-	p.close = wss.Close
+// 同时监听tcp端口，可以调用多次，需要在Run之前调用
+func (p *Mgr) ListenTCP(addr string) {
+	p.tcpAddrs = append(p.tcpAddrs, addr)
+}
+
+// 同时监听可靠udp端口，适合实时对战等对延迟敏感的客户端，需要在Run之前调用
+func (p *Mgr) ListenKCP(addr string, conf KCPConfig) {
+	if p.kcpAddrs == nil {
+		p.kcpAddrs = make(map[string]KCPConfig)
+	}
+	p.kcpAddrs[addr] = conf
+}
+
+// websocket和tcp监听使用tls，需要在Run之前调用
+func (p *Mgr) SetTLS(conf TLSConfig) {
+	p.tlsConf = &conf
+}
+
+// 手动重新加载证书，如收到SIGHUP时
+func (p *Mgr) ReloadCert() error {
+	if p.certReloader == nil {
+		return ErrTLSConfig
+	}
+	return p.certReloader.Reload()
+}
+
+// wsAddr为空时只监听tcp、kcp，tls配置错误或任意端口监听失败时关闭已经启动的监听并返回错误
+func (p *Mgr) Run() error {
+	var tlsConf *tls.Config
+	if p.tlsConf != nil {
+		conf, reloader, err := p.tlsConf.Build()
+		if err != nil {
+			logger.DefaultLogger.Errorx("Mgr tls config: %s", nil, err.Error())
+			return err
+		}
+		tlsConf = conf
+		p.certReloader = reloader
+	}
+	done := make(chan struct{})
+	p.close = func() {
+		close(done)
+		if p.wss != nil {
+			p.wss.Close()
+		}
+		for _, v := range p.tcps {
+			v.Close()
+		}
+		for _, v := range p.kcps {
+			v.Close()
+		}
+		if p.certReloader != nil {
+			p.certReloader.Close()
+		}
+	}
+	if err := p.listen(tlsConf); err != nil {
+		p.Close()
+		return err
+	}
+	go p.broadcastLoop(done)
+	if p.heartbeat != nil {
+		go p.heartbeatLoop(done)
+	}
+	return nil
+}
+
+func (p *Mgr) listen(tlsConf *tls.Config) error {
+	if p.wsAddr != "" {
+		wss := NewWSServer(p.wsAddr, func(conn Conn) ConnInvoker {
+			return p.newClient(conn, "ws")
+		})
+		wss.SetTLS(tlsConf)
+		if err := wss.Start(); err != nil {
+			return err
+		}
+		p.wss = wss
+	}
+	for _, addr := range p.tcpAddrs {
+		tcp := NewTCPServer(addr, p.processor.littleEndian, func(conn Conn) ConnInvoker {
+			return p.newClient(conn, "tcp")
+		})
+		tcp.SetTLS(tlsConf)
+		if err := tcp.Start(); err != nil {
+			return err
+		}
+		p.tcps = append(p.tcps, tcp)
+	}
+	for addr, conf := range p.kcpAddrs {
+		kcp := NewKCPServer(addr, conf, func(conn Conn) ConnInvoker {
+			return p.newClient(conn, "kcp")
+		})
+		if err := kcp.Start(); err != nil {
+			return err
+		}
+		p.kcps = append(p.kcps, kcp)
+	}
+	return nil
 }
 
+func (p *Mgr) newClient(conn Conn, network string) *Client {
+	id := atomic.AddInt32(&p.sesID, 1)
+	c := NewClient(&sesConn{Conn: conn, id: id}, p)
+	c.network = network
+	c.limiter = p.newSessionLimiter()
+	if pc, ok := conn.(pingConn); ok {
+		c.pinger = pc
+		pc.SetPongHandler(func(data []byte) {
+			if len(data) == 8 {
+				c.onPong(int64(binary.BigEndian.Uint64(data)))
+			}
+		})
+	}
+	return c
+}
+
+// 替换底层连接的id
+type sesConn struct {
+	Conn
+	id int32
+}
+
+func (p *sesConn) ID() int32 {
+	return p.id
+}
+
+// websocket的监听地址，没有监听websocket时返回nil
 func (p *Mgr) ListenAddr() *net.TCPAddr {
+	if p.wss == nil {
+		return nil
+	}
 	return p.wss.ListenAddr()
 }
 
+func (p *Mgr) TCPListenAddrs() []*net.TCPAddr {
+	ret := make([]*net.TCPAddr, 0, len(p.tcps))
+	for _, v := range p.tcps {
+		ret = append(ret, v.ListenAddr())
+	}
+	return ret
+}
+
+func (p *Mgr) KCPListenAddrs() []*net.UDPAddr {
+	ret := make([]*net.UDPAddr, 0, len(p.kcps))
+	for _, v := range p.kcps {
+		ret = append(ret, v.ListenAddr())
+	}
+	return ret
+}
+
 func (p *Mgr) Close() {
-	p.close()
+	if p.close != nil {
+		p.close()
+		p.close = nil
+	}
+}
+
+func (p *Mgr) Post(f func()) error {
+	return p.worker.Post(f)
+}
+
+// 按session分片，同一个session的消息顺序执行
+func (p *Mgr) PostKey(sesID int32, f func()) error {
+	return p.worker.PostKey(uint32(sesID), f)
+}
+
+func (p *Mgr) postSession(sesID int32, source string, pri Priority, f func()) error {
+	return p.worker.PostTask(Task{Key: uint32(sesID), Source: source, Priority: pri, F: f})
 }
 
-func (p *Mgr) Post(f func()) {
-	p.worker.Post(f)
+// 设置客户端消息在worker中的优先级
+func (p *Mgr) SetPriority(msg proto.Message, pri Priority) {
+	p.processor.SetPriority(msg, pri)
+}
+
+func (p *Mgr) addClient(c *Client) {
+	p.sesMutex.Lock()
+	defer p.sesMutex.Unlock()
+	p.sesID2Client[c.ID()] = c
+	if uid := c.UserID(); uid != 0 {
+		p.user2Client[uid] = c
+	}
+}
+
+func (p *Mgr) removeClient(c *Client) {
+	p.groupMutex.Lock()
+	defer p.groupMutex.Unlock()
+
+	var empty []string
+	p.sesMutex.Lock()
+	delete(p.sesID2Client, c.ID())
+	if uid := c.UserID(); uid != 0 && p.user2Client[uid] == c {
+		delete(p.user2Client, uid)
+	}
+	for group := range c.groups {
+		if p.leaveGroup(group, c.ID()) {
+			empty = append(empty, group)
+		}
+	}
+	c.groups = nil
+	p.sesMutex.Unlock()
+
+	for _, group := range empty {
+		p.onGroupEmptied(group)
+	}
 }
 
+// 还没有加入sesID2Client时由addClient添加
+func (p *Mgr) bindUser(c *Client, old, userID int64) {
+	p.sesMutex.Lock()
+	defer p.sesMutex.Unlock()
+	if p.sesID2Client[c.ID()] != c {
+		return
+	}
+	if old != 0 && p.user2Client[old] == c {
+		delete(p.user2Client, old)
+	}
+	if userID != 0 {
+		p.user2Client[userID] = c
+	}
+}
+
+// 使用ShardedWorker时onNew、onClose会在不同分片并发调用
 func (p *Mgr) RegisterEvent(onNew, onClose func(conn Session)) {
 	p.onNew = onNew
 	p.onClose = onClose
@@ -117,6 +344,30 @@ func (p *Mgr) GetSession(sesID int32) (Session, bool) {
 	return v, ok
 }
 
+func (p *Mgr) GetSessionByUser(userID int64) (Session, bool) {
+	p.sesMutex.Lock()
+	defer p.sesMutex.Unlock()
+
+	v, ok := p.user2Client[userID]
+	return v, ok
+}
+
+// 断开session，reason不为空时先发送rpcmsg.Kick，session不存在时返回false
+func (p *Mgr) CloseSession(sesID int32, reason string) bool {
+	p.sesMutex.Lock()
+	c, ok := p.sesID2Client[sesID]
+	p.sesMutex.Unlock()
+	if !ok {
+		return false
+	}
+	if reason == "" {
+		c.Close()
+	} else {
+		c.kick(rpcmsg.Kick_Kicked, reason)
+	}
+	return true
+}
+
 //func (p *Mgr) RegisterSessionMsgHandler(msg proto.Message, f func(Session, proto.Message)) {
 //	p.processor.Register(msg)
 //	p.processor.SetHandler(msg, f)
@@ -139,8 +390,9 @@ func (p *Mgr) RegisterRawSessionMsgHandler(msg proto.Message, handler MsgHandler
 }
 
 type Processor struct {
-	littleEndian bool
-	msgID2Info   map[uint32]*MsgInfo
+	littleEndian   bool
+	msgID2Info     map[uint32]*MsgInfo
+	msgID2Priority map[uint32]Priority
 }
 
 type MsgInfo struct {
@@ -154,6 +406,7 @@ func NewProcessor() *Processor {
 	p := new(Processor)
 	p.littleEndian = false
 	p.msgID2Info = make(map[uint32]*MsgInfo)
+	p.msgID2Priority = make(map[uint32]Priority)
 	return p
 }
 
@@ -198,6 +451,17 @@ func (p *Processor) RegisterSessionMsgHandler(msg proto.Message, handler MsgHand
 	p.msgID2Info[msgID] = msgInfo
 }
 
+// 设置消息在worker中的优先级，默认PriorityNormal
+func (p *Processor) SetPriority(msg proto.Message, pri Priority) {
+	msgID, _ := ProtoHash(msg)
+	p.msgID2Priority[msgID] = pri
+}
+
+func (p *Processor) GetPriority(msg proto.Message) Priority {
+	msgID, _ := ProtoHash(msg)
+	return p.msgID2Priority[msgID]
+}
+
 func ProtoHash(msg proto.Message) (uint32, reflect.Type) {
 	msgName := proto.MessageName(msg)
 	return CRC32Hash(string(msgName)), reflect.TypeOf(msg)
@@ -0,0 +1,178 @@
+package natsrpc
+
+import (
+	"errors"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wwqdrh/gokit/logger"
+)
+
+var (
+	ErrPoolClosed  = errors.New("pool: closed")
+	ErrLimiterFull = errors.New("pool: limiter full")
+)
+
+// Limiter默认的最大排队任务数
+const DefaultLimiterPending = 10240
+
+// 有界协程池，用于不需要保证顺序、可能阻塞的handler(如访问db)
+type Pool struct {
+	size    int
+	funChan chan func()
+	wg      sync.WaitGroup
+
+	// 与Work相同，Post持有读锁，关闭时加写锁等待进行中的Post结束后再关闭funChan
+	postMu sync.RWMutex
+	closed int32
+	quit   chan struct{} //唤醒阻塞中的Post
+}
+
+func NewPool(size int) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	p := new(Pool)
+	p.size = size
+	p.funChan = make(chan func(), 10240)
+	p.quit = make(chan struct{})
+	return p
+}
+
+func (p *Pool) Run() {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for f := range p.funChan {
+				p.protectedFun(f)
+			}
+		}()
+	}
+}
+
+// 队列满时阻塞，关闭后返回ErrPoolClosed
+func (p *Pool) Post(f func()) error {
+	p.postMu.RLock()
+	defer p.postMu.RUnlock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return ErrPoolClosed
+	}
+	select {
+	case p.funChan <- f:
+		return nil
+	case <-p.quit:
+		return ErrPoolClosed
+	}
+}
+
+// 关闭后等待队列中和正在执行的任务结束
+func (p *Pool) Close() {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return
+	}
+	close(p.quit)
+	p.postMu.Lock()
+	close(p.funChan)
+	p.postMu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Pool) Len() int {
+	return len(p.funChan)
+}
+
+func (p *Pool) protectedFun(callback func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.DefaultLogger.Errorx("pool task panic: %v", nil, err)
+			debug.PrintStack()
+		}
+	}()
+	callback()
+}
+
+// 单个handler的并发上限，超过上限的任务在limiter内排队，不占用池中的协程
+type Limiter struct {
+	pool       *Pool
+	limit      int
+	maxPending int
+
+	mu      sync.Mutex
+	running int
+	pending []func()
+}
+
+func (p *Pool) NewLimiter(limit int) *Limiter {
+	if limit <= 0 {
+		limit = p.size
+	}
+	return &Limiter{
+		pool:       p,
+		limit:      limit,
+		maxPending: DefaultLimiterPending,
+	}
+}
+
+// 设置最大排队任务数，超过时Post返回ErrLimiterFull
+func (l *Limiter) SetMaxPending(n int) {
+	if n <= 0 {
+		n = DefaultLimiterPending
+	}
+	l.mu.Lock()
+	l.maxPending = n
+	l.mu.Unlock()
+}
+
+func (l *Limiter) Post(f func()) error {
+	if atomic.LoadInt32(&l.pool.closed) != 0 {
+		return ErrPoolClosed
+	}
+	l.mu.Lock()
+	if l.running >= l.limit {
+		if len(l.pending) >= l.maxPending {
+			l.mu.Unlock()
+			return ErrLimiterFull
+		}
+		l.pending = append(l.pending, f)
+		l.mu.Unlock()
+		return nil
+	}
+	l.running++
+	l.mu.Unlock()
+
+	err := l.pool.Post(func() {
+		l.run(f)
+	})
+	if err != nil {
+		l.mu.Lock()
+		l.running--
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// 执行完后在同一协程里继续消费排队的任务，保证并发数不超过limit
+func (l *Limiter) run(f func()) {
+	for f != nil {
+		l.pool.protectedFun(f)
+
+		l.mu.Lock()
+		if len(l.pending) > 0 {
+			f = l.pending[0]
+			l.pending[0] = nil
+			l.pending = l.pending[1:]
+		} else {
+			f = nil
+			l.running--
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pending)
+}
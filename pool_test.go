@@ -0,0 +1,79 @@
+package natsrpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	pool := NewPool(8)
+	pool.Run()
+	defer pool.Close()
+
+	l := pool.NewLimiter(2)
+	l.SetMaxPending(4)
+	var running, peak int32
+	block := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		err := l.Post(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+			<-block
+			atomic.AddInt32(&running, -1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2个执行中，4个排队，再投递时超过上限
+	if err := l.Post(func() {}); err != ErrLimiterFull {
+		t.Errorf("expect ErrLimiterFull, got %v", err)
+	}
+	close(block)
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("limit exceeded: %d", peak)
+	}
+	if l.Len() != 0 {
+		t.Errorf("pending not empty: %d", l.Len())
+	}
+}
+
+// 关闭与投递并发时不能panic，关闭后返回ErrPoolClosed
+func TestPoolCloseRace(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		pool := NewPool(2)
+		pool.Run()
+		l := pool.NewLimiter(1)
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 100; k++ {
+					pool.Post(func() {})
+					l.Post(func() {})
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		pool.Close()
+		wg.Wait()
+		if err := pool.Post(func() {}); err != ErrPoolClosed {
+			t.Fatalf("expect ErrPoolClosed, got %v", err)
+		}
+		if err := l.Post(func() {}); err != ErrPoolClosed {
+			t.Fatalf("expect limiter ErrPoolClosed, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package natsrpc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+)
+
+// 超过限制时的处理方式
+type LimitPolicy int
+
+const (
+	LimitDrop  LimitPolicy = iota //丢弃消息
+	LimitWarn                     //丢弃消息并发送rpcmsg.Throttled
+	LimitClose                    //发送rpcmsg.Kick后断开
+)
+
+// 令牌桶，Rate为每秒的消息数，Burst为允许的突发数，为0时等于Rate
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type SessionLimitConfig struct {
+	Rate RateLimit `json:"rate"` //所有消息的频率，Rate为0时不限制
+	// 单个消息的最大字节数，为0时不限制，超过传输层的最大长度时直接断开
+	MaxFrame int `json:"max_frame"`
+	// 每个session在worker中等待执行的最大任务数，为0时不限制
+	MaxPending int         `json:"max_pending"`
+	Policy     LimitPolicy `json:"policy"`
+}
+
+// 客户端消息的频率和大小限制，认证阶段同样生效，心跳消息不受限制，需要在Run之前调用
+func (p *Mgr) SetSessionLimit(conf SessionLimitConfig) {
+	p.sessionLimit = &conf
+}
+
+// 单个消息类型的频率限制，与SetSessionLimit的Rate同时生效，需要在Run之前调用
+func (p *Mgr) SetMsgRateLimit(msg proto.Message, limit RateLimit) {
+	if p.msgRateLimits == nil {
+		p.msgRateLimits = make(map[uint32]RateLimit)
+	}
+	msgID, _ := ProtoHash(msg)
+	p.msgRateLimits[msgID] = limit
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (p *tokenBucket) allow(now time.Time) bool {
+	p.tokens += now.Sub(p.last).Seconds() * p.rate
+	if p.tokens > p.burst {
+		p.tokens = p.burst
+	}
+	p.last = now
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+// 只在读协程中使用
+type sessionLimiter struct {
+	conf    *SessionLimitConfig
+	all     *tokenBucket
+	msgs    map[uint32]*tokenBucket
+	pending int32 //原子操作，worker中执行后减少
+}
+
+func (p *Mgr) newSessionLimiter() *sessionLimiter {
+	if p.sessionLimit == nil && len(p.msgRateLimits) == 0 {
+		return nil
+	}
+	conf := p.sessionLimit
+	if conf == nil {
+		conf = &SessionLimitConfig{}
+	}
+	ret := &sessionLimiter{conf: conf, msgs: make(map[uint32]*tokenBucket)}
+	if conf.Rate.Rate > 0 {
+		ret.all = newTokenBucket(conf.Rate)
+	}
+	return ret
+}
+
+// 返回不为空时超过限制
+func (p *sessionLimiter) checkFrame(n int) string {
+	if p.conf.MaxFrame > 0 && n > p.conf.MaxFrame {
+		return fmt.Sprintf("message too large: %d > %d", n, p.conf.MaxFrame)
+	}
+	return ""
+}
+
+func (p *sessionLimiter) checkRate(mgr *Mgr, msgID uint32) string {
+	now := time.Now()
+	if p.all != nil && !p.all.allow(now) {
+		return "rate limited"
+	}
+	bucket, ok := p.msgs[msgID]
+	if !ok {
+		if limit, ok := mgr.msgRateLimits[msgID]; ok && limit.Rate > 0 {
+			bucket = newTokenBucket(limit)
+		}
+		p.msgs[msgID] = bucket
+	}
+	if bucket != nil && !bucket.allow(now) {
+		return "message rate limited"
+	}
+	return ""
+}
+
+// 投递前调用，返回false时等待执行的任务过多，成功时任务执行或丢弃后调用done
+func (p *sessionLimiter) acquire() bool {
+	if p.conf.MaxPending <= 0 {
+		return true
+	}
+	if atomic.AddInt32(&p.pending, 1) > int32(p.conf.MaxPending) {
+		atomic.AddInt32(&p.pending, -1)
+		return false
+	}
+	return true
+}
+
+func (p *sessionLimiter) done() {
+	if p.conf.MaxPending > 0 {
+		atomic.AddInt32(&p.pending, -1)
+	}
+}
+
+// 按策略处理超过限制的消息，返回false时断开连接
+func (p *Client) onLimit(msgID uint32, reason string) bool {
+	switch p.limiter.conf.Policy {
+	case LimitWarn:
+		p.SendMsg(&rpcmsg.Throttled{Msgid: msgID, Reason: reason})
+	case LimitClose:
+		p.kick(rpcmsg.Kick_RateLimited, reason)
+		return false
+	}
+	return true
+}
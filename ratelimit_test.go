@@ -0,0 +1,188 @@
+package natsrpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wwqdrh/natsrpc/engine/rpcmsg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 2})
+	now := b.last
+	if !b.allow(now) || !b.allow(now) || b.allow(now) {
+		t.Error("burst not equal")
+	}
+	if !b.allow(now.Add(100*time.Millisecond)) || b.allow(now.Add(100*time.Millisecond)) {
+		t.Error("refill not equal")
+	}
+}
+
+func TestMgrSessionLimit(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	block := make(chan struct{})
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) {})
+	mgr.SetSessionLimit(SessionLimitConfig{MaxFrame: 32, MaxPending: 2, Policy: LimitWarn})
+	mgr.SetMsgRateLimit(&wrapperspb.Int32Value{}, RateLimit{Rate: 0.01, Burst: 2})
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.Int32Value) {
+		s.SendMsg(msg)
+	})
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.BoolValue) {
+		<-block
+	})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&rpcmsg.Throttled{}, nil)
+	client.RegisterSessionMsgHandler(&wrapperspb.Int32Value{}, nil)
+	conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	read := func() string {
+		msg, err := readTCPMsg(conn, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := msg.(*rpcmsg.Throttled); ok {
+			return m.Reason
+		}
+		return "echo"
+	}
+	expect := func(want string) {
+		if got := read(); !strings.HasPrefix(got, want) {
+			t.Fatalf("expect %s, got %s", want, got)
+		}
+	}
+
+	// Throttled在读协程中发送，与worker中的回复顺序不确定
+	for i := 0; i < 3; i++ {
+		writeTCPMsg(conn, client, wrapperspb.Int32(int32(i)))
+	}
+	got := map[string]int{}
+	for i := 0; i < 3; i++ {
+		got[read()]++
+	}
+	if got["echo"] != 2 || got["message rate limited"] != 1 {
+		t.Fatalf("rate limit not equal: %v", got)
+	}
+
+	writeTCPMsg(conn, client, wrapperspb.String(strings.Repeat("a", 64)))
+	expect("message too large")
+
+	// 前两个在worker中阻塞
+	for i := 0; i < 3; i++ {
+		writeTCPMsg(conn, client, wrapperspb.Bool(true))
+	}
+	expect("too many pending messages")
+	close(block)
+}
+
+func TestMgrSessionLimitClose(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) {})
+	mgr.SetSessionLimit(SessionLimitConfig{Rate: RateLimit{Rate: 0.01, Burst: 1}, Policy: LimitClose})
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.Int32Value) {})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&rpcmsg.Kick{}, nil)
+	conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	writeTCPMsg(conn, client, wrapperspb.Int32(1))
+	writeTCPMsg(conn, client, wrapperspb.Int32(2))
+	msg, err := readTCPMsg(conn, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kick := msg.(*rpcmsg.Kick); kick.Code != rpcmsg.Kick_RateLimited {
+		t.Errorf("kick not equal: %v", kick)
+	}
+	if _, err := readTCPMsg(conn, client); err == nil {
+		t.Error("conn not closed")
+	}
+}
+
+// 认证阶段同样受频率限制，超过时不再调用AuthHandler
+func TestMgrSessionLimitAuth(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) {})
+	mgr.SetSessionLimit(SessionLimitConfig{Rate: RateLimit{Rate: 0.01, Burst: 3}, MaxFrame: 32, Policy: LimitClose})
+	calls := make(chan struct{}, 16)
+	mgr.SetAuth(time.Second, func(s Session, msg proto.Message) error {
+		calls <- struct{}{}
+		return ErrAuthContinue
+	})
+	mgr.RegisterAuthMsg(&wrapperspb.StringValue{})
+	mgr.Run()
+	defer mgr.Close()
+
+	client := NewProcessor()
+	client.RegisterSessionMsgHandler(&rpcmsg.Kick{}, nil)
+	conn, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 10; i++ {
+		writeTCPMsg(conn, client, wrapperspb.String("token"))
+	}
+	msg, err := readTCPMsg(conn, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kick, ok := msg.(*rpcmsg.Kick); !ok || kick.Code != rpcmsg.Kick_RateLimited {
+		t.Errorf("kick not equal: %v", msg)
+	}
+	if len(calls) != 3 {
+		t.Errorf("auth calls not equal: %d", len(calls))
+	}
+
+	// 超过MaxFrame的认证消息直接断开
+	conn2, err := net.Dial("tcp", mgr.TCPListenAddrs()[0].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.SetDeadline(time.Now().Add(time.Second))
+	writeTCPMsg(conn2, client, wrapperspb.String(strings.Repeat("x", 64)))
+	msg, err = readTCPMsg(conn2, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kick, ok := msg.(*rpcmsg.Kick); !ok || kick.Code != rpcmsg.Kick_RateLimited {
+		t.Errorf("frame kick not equal: %v", msg)
+	}
+	if len(calls) != 3 {
+		t.Errorf("oversize message reached auth: %d", len(calls))
+	}
+}
@@ -0,0 +1,153 @@
+package natsrpc
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+// 分片worker，每个分片一个协程，相同key(sesid、userid、roomid等)的任务始终在同一个分片中顺序执行
+// 不带key的Post、PostCtx、定时器、ticker和RunFrame的tick都在0号分片执行，与其他分片上带key的任务(如session消息)并行
+// 从Work换成ShardedWorker时，这些任务与session handler共享的数据需要加锁或通过PostKey切到对应分片
+type ShardedWorker struct {
+	shards []*Work
+}
+
+func NewShardedWorker(n int) Worker {
+	return NewShardedWorkerWithConfig(n, WorkerConfig{})
+}
+
+// 每个分片使用相同的配置，QueueSize为单个分片的队列长度
+func NewShardedWorkerWithConfig(n int, conf WorkerConfig) Worker {
+	if n <= 0 {
+		n = 1
+	}
+	p := new(ShardedWorker)
+	p.shards = make([]*Work, n)
+	for i := range p.shards {
+		p.shards[i] = NewWorkerWithConfig(conf).(*Work)
+	}
+	return p
+}
+
+func (p *ShardedWorker) shard(key uint32) *Work {
+	return p.shards[key%uint32(len(p.shards))]
+}
+
+func (p *ShardedWorker) Post(f func()) error {
+	return p.shards[0].Post(f)
+}
+
+func (p *ShardedWorker) PostKey(key uint32, f func()) error {
+	return p.shard(key).PostKey(key, f)
+}
+
+func (p *ShardedWorker) PostPriority(pri Priority, f func()) error {
+	return p.shards[0].PostPriority(pri, f)
+}
+
+func (p *ShardedWorker) PostCtx(ctx context.Context, f func(ctx context.Context)) error {
+	return p.shards[0].PostCtx(ctx, f)
+}
+
+// 所有分片共用0号分片的ctx
+func (p *ShardedWorker) Context() context.Context {
+	return p.shards[0].Context()
+}
+
+func (p *ShardedWorker) PostTask(t Task) error {
+	return p.shard(t.Key).PostTask(t)
+}
+
+func (p *ShardedWorker) Run() {
+	for _, v := range p.shards {
+		v.Run()
+	}
+}
+
+// 0号分片执行帧循环，其他分片仍然按事件处理
+// tick与其他分片上的session消息并行执行，不能像Work一样直接访问session handler的数据
+func (p *ShardedWorker) RunFrame(tick func(Frame)) {
+	if len(p.shards) > 1 {
+		logger.DefaultLogger.Warn("RunFrame on sharded worker, tick runs concurrently with keyed tasks", zap.Int("shards", len(p.shards)))
+	}
+	p.shards[0].RunFrame(tick)
+	for _, v := range p.shards[1:] {
+		v.Run()
+	}
+}
+
+func (p *ShardedWorker) Close() {
+	for _, v := range p.shards {
+		v.Close()
+	}
+}
+
+func (p *ShardedWorker) Shutdown(ctx context.Context) error {
+	errs := make(chan error, len(p.shards))
+	for _, v := range p.shards {
+		go func(w *Work) {
+			errs <- w.Shutdown(ctx)
+		}(v)
+	}
+	var ret error
+	for range p.shards {
+		if err := <-errs; err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+func (p *ShardedWorker) AfterPost(d time.Duration, f func()) *time.Timer {
+	return p.shards[0].AfterPost(d, f)
+}
+
+func (p *ShardedWorker) AfterPostPriority(d time.Duration, pri Priority, f func()) *time.Timer {
+	return p.shards[0].AfterPostPriority(d, pri, f)
+}
+
+func (p *ShardedWorker) NewTicker(d time.Duration, f func()) io.Closer {
+	return p.shards[0].NewTicker(d, f)
+}
+
+func (p *ShardedWorker) NewTickerPriority(d time.Duration, pri Priority, f func()) io.Closer {
+	return p.shards[0].NewTickerPriority(d, pri, f)
+}
+
+func (p *ShardedWorker) track(c io.Closer) bool {
+	return p.shards[0].track(c)
+}
+
+func (p *ShardedWorker) untrack(c io.Closer) {
+	p.shards[0].untrack(c)
+}
+
+func (p *ShardedWorker) Len() int {
+	n := 0
+	for _, v := range p.shards {
+		n += v.Len()
+	}
+	return n
+}
+
+func (p *ShardedWorker) Stats() map[string]TaskStat {
+	ret := make(map[string]TaskStat)
+	for _, v := range p.shards {
+		for source, stat := range v.Stats() {
+			ret[source] = ret[source].merge(stat)
+		}
+	}
+	return ret
+}
+
+func (p *ShardedWorker) FrameStats() FrameStat {
+	return p.shards[0].FrameStats()
+}
+
+func (p *ShardedWorker) Shards() int {
+	return len(p.shards)
+}
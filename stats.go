@@ -0,0 +1,94 @@
+package natsrpc
+
+import (
+	"sync"
+	"time"
+)
+
+type TaskStat struct {
+	Count     int64
+	Skipped   int64         //ctx已结束未执行的任务数
+	WaitTotal time.Duration //排队时间
+	WaitMax   time.Duration
+	RunTotal  time.Duration //执行时间
+	RunMax    time.Duration
+}
+
+func (p TaskStat) WaitAvg() time.Duration {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.WaitTotal / time.Duration(p.Count)
+}
+
+func (p TaskStat) RunAvg() time.Duration {
+	if p.Count == 0 {
+		return 0
+	}
+	return p.RunTotal / time.Duration(p.Count)
+}
+
+func (p TaskStat) merge(o TaskStat) TaskStat {
+	p.Count += o.Count
+	p.Skipped += o.Skipped
+	p.WaitTotal += o.WaitTotal
+	p.RunTotal += o.RunTotal
+	if o.WaitMax > p.WaitMax {
+		p.WaitMax = o.WaitMax
+	}
+	if o.RunMax > p.RunMax {
+		p.RunMax = o.RunMax
+	}
+	return p
+}
+
+type workStats struct {
+	sync.Mutex
+	sources map[string]*TaskStat
+}
+
+func newWorkStats() *workStats {
+	return &workStats{sources: make(map[string]*TaskStat)}
+}
+
+func (p *workStats) get(source string) *TaskStat {
+	s, ok := p.sources[source]
+	if !ok {
+		s = new(TaskStat)
+		p.sources[source] = s
+	}
+	return s
+}
+
+func (p *workStats) skip(source string) {
+	p.Lock()
+	defer p.Unlock()
+	p.get(source).Skipped++
+}
+
+func (p *workStats) record(source string, wait, run time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	s := p.get(source)
+	s.Count++
+	s.WaitTotal += wait
+	s.RunTotal += run
+	if wait > s.WaitMax {
+		s.WaitMax = wait
+	}
+	if run > s.RunMax {
+		s.RunMax = run
+	}
+}
+
+func (p *workStats) snapshot() map[string]TaskStat {
+	p.Lock()
+	defer p.Unlock()
+
+	ret := make(map[string]TaskStat, len(p.sources))
+	for k, v := range p.sources {
+		ret[k] = *v
+	}
+	return ret
+}
@@ -1,59 +1,172 @@
 package stub
 
 import (
+	"context"
+	"sync"
 	"time"
 
+	"github.com/wwqdrh/gokit/logger"
 	"github.com/wwqdrh/natsrpc"
 	"github.com/wwqdrh/natsrpc/engine"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
 type Gate struct {
-	worker      natsrpc.Worker
-	rpc         *engine.RPC
-	networkMgr  *natsrpc.Mgr
-	onCloseFuns []func()
+	worker         natsrpc.Worker
+	rpc            *engine.RPC
+	networkMgr     *natsrpc.Mgr
+	onCloseFuns    []func()
+	closeOnce      sync.Once //Close和Shutdown只执行一次关闭事件
+	onNew, onClose func(conn natsrpc.Session)
+
+	routedMu sync.Mutex
+	routed   map[int32]map[int32]struct{} //session路由过的服务器，断开时通知
+	pins     *pinTable
 }
 
+// config.Shards大于1时session消息在多个协程中执行，见natsrpc.ShardedWorker
 func NewGate(serverID int32, addr string, config natsrpc.Config) (*Gate, error) {
 	p := new(Gate)
-	p.worker = natsrpc.NewWorker()
+	p.worker = config.NewWorker()
 	p.networkMgr = natsrpc.NewMgr(addr, p.worker)
+	p.routed = make(map[int32]map[int32]struct{})
 	rpc, err := engine.NewRPC(serverID, p.worker, config.Nats)
 	if err != nil {
 		return nil, err
 	}
 	p.rpc = rpc
+	p.pins = newPinTable(rpc)
 	p.rpc.RegisterSend2Session(func(sesID int32, msgID uint32, data []byte) {
 		if ses, ok := p.GetSession(sesID); ok {
 			ses.SendRawMsg(msgID, data)
 		}
 	})
+	p.rpc.SetServerType(engine.Gate)
+	if err := p.rpc.WatchServers(); err != nil {
+		return nil, err
+	}
+	p.rpc.RegisterRebind(p.rebind)
+	p.rpc.RegisterCloseSession(func(sesID int32, msgID uint32, data []byte) {
+		if ses, ok := p.GetSession(sesID); ok {
+			if msgID != 0 {
+				ses.SendRawMsg(msgID, data)
+			}
+			ses.Close()
+		}
+	})
+	p.rpc.RegisterGroup(func(group string, sesID int32, join bool) {
+		if join {
+			p.networkMgr.JoinGroup(group, sesID)
+		} else {
+			p.networkMgr.LeaveGroup(group, sesID)
+		}
+	}, p.networkMgr.MulticastRaw)
+	if err := p.rpc.RegisterBroadcast(func(msgID uint32, data []byte, filter map[string]string) {
+		if err := p.networkMgr.BroadcastRaw(msgID, data, filter); err != nil {
+			logger.DefaultLogger.Error("gate drop broadcast", zap.Int32("gateID", serverID), zap.Uint32("msgID", msgID), zap.Error(err))
+		}
+	}); err != nil {
+		return nil, err
+	}
+	p.networkMgr.RegisterEvent(p.sessionOpened, p.sessionClosed)
+	p.networkMgr.RegisterGroupEvent(func(group string) {
+		if err := p.rpc.SubscribeGroup(group); err != nil {
+			logger.DefaultLogger.Error("subscribe group", zap.String("group", group), zap.Error(err))
+		}
+	}, func(group string) {
+		p.rpc.UnsubscribeGroup(group)
+	})
 	return p, nil
 }
 
-// TODO 添加关闭信号
-func (p *Gate) Run() {
+// 在websocket之外同时监听tcp，与websocket共用session，需要在Run之前调用
+func (p *Gate) ListenTCP(addr string) {
+	p.networkMgr.ListenTCP(addr)
+}
+
+// 同时监听可靠udp，与websocket共用session，需要在Run之前调用
+func (p *Gate) ListenKCP(addr string, conf natsrpc.KCPConfig) {
+	p.networkMgr.ListenKCP(addr, conf)
+}
+
+// websocket(wss)和tcp使用tls，证书文件变化时自动重新加载，需要在Run之前调用
+func (p *Gate) SetTLS(conf natsrpc.TLSConfig) {
+	p.networkMgr.SetTLS(conf)
+}
+
+// 连接建立后先认证，handler在连接的读协程中执行，可以调用GetServerById(id).Call请求认证服务器
+// 需要在Run之前调用
+func (p *Gate) SetAuth(timeout time.Duration, handler natsrpc.AuthHandler) {
+	p.networkMgr.SetAuth(timeout, handler)
+}
+
+// 注册认证消息，只在认证阶段交给AuthHandler
+func (p *Gate) RegisterAuthMsg(msgs ...proto.Message) {
+	p.networkMgr.RegisterAuthMsg(msgs...)
+}
+
+// 开启心跳，超过Timeout没有收到消息的session会被断开，需要在Run之前调用
+func (p *Gate) SetHeartbeat(conf natsrpc.HeartbeatConfig) {
+	p.networkMgr.SetHeartbeat(conf)
+}
+
+// 每个session的消息频率、大小和排队任务数限制，需要在Run之前调用
+func (p *Gate) SetSessionLimit(conf natsrpc.SessionLimitConfig) {
+	p.networkMgr.SetSessionLimit(conf)
+}
+
+// 单个消息类型的频率限制，需要在Run之前调用
+func (p *Gate) SetMsgRateLimit(msg proto.Message, limit natsrpc.RateLimit) {
+	p.networkMgr.SetMsgRateLimit(msg, limit)
+}
+
+// 网络监听失败时停止rpc和worker并返回错误
+func (p *Gate) Run() error {
 	p.worker.Run()
 	p.rpc.Run()
-	p.networkMgr.Run()
+	if err := p.networkMgr.Run(); err != nil {
+		p.rpc.Close()
+		p.worker.Close()
+		return err
+	}
+	return nil
 }
 
 func (p *Gate) Close() {
-	for _, v := range p.onCloseFuns {
-		v()
-	}
+	p.closeOnce.Do(func() {
+		for _, v := range p.onCloseFuns {
+			v()
+		}
+	})
+	p.networkMgr.Close()
 	p.rpc.Close()
 	p.worker.Close()
 }
 
+// 停止接收网络和rpc消息，执行完worker中剩余的任务后返回
+func (p *Gate) Shutdown(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		for _, v := range p.onCloseFuns {
+			v()
+		}
+	})
+	p.networkMgr.Close()
+	p.rpc.Close()
+	return p.worker.Shutdown(ctx)
+}
+
 // 注册关闭事件 worker线程外
 func (p *Gate) RegisterCloseFunc(f func()) {
 	p.onCloseFuns = append(p.onCloseFuns, f)
 }
 
-func (p *Gate) Post(f func()) {
-	p.worker.Post(f)
+func (p *Gate) Worker() natsrpc.Worker {
+	return p.worker
+}
+
+func (p *Gate) Post(f func()) error {
+	return p.worker.Post(f)
 }
 
 func (p *Gate) AfterPost(duration time.Duration, f func()) {
@@ -61,7 +174,48 @@ func (p *Gate) AfterPost(duration time.Duration, f func()) {
 }
 
 func (p *Gate) RegisterNetWorkEvent(onNew, onClose func(conn natsrpc.Session)) {
-	p.networkMgr.RegisterEvent(onNew, onClose)
+	p.onNew = onNew
+	p.onClose = onClose
+}
+
+func (p *Gate) sessionOpened(s natsrpc.Session) {
+	p.rpc.PublishSessionEvent(s, true)
+	if p.onNew != nil {
+		p.onNew(s)
+	}
+}
+
+// 通知路由过的服务器，后端通过engine.RPC.RegisterSessionEvent清理状态
+func (p *Gate) sessionClosed(s natsrpc.Session) {
+	p.routedMu.Lock()
+	servers := p.routed[s.ID()]
+	delete(p.routed, s.ID())
+	p.routedMu.Unlock()
+	p.pins.remove(s.ID())
+	for serverID := range servers {
+		p.rpc.SendSessionEvent(serverID, s, false)
+	}
+	p.rpc.PublishSessionEvent(s, false)
+	if p.onClose != nil {
+		p.onClose(s)
+	}
+}
+
+// 第一次路由到serverID时先发送SessionOpened
+func (p *Gate) routeSession(s natsrpc.Session, serverID int32, msg proto.Message) {
+	p.routedMu.Lock()
+	servers, ok := p.routed[s.ID()]
+	if !ok {
+		servers = make(map[int32]struct{})
+		p.routed[s.ID()] = servers
+	}
+	_, routed := servers[serverID]
+	servers[serverID] = struct{}{}
+	p.routedMu.Unlock()
+	if !routed {
+		p.rpc.SendSessionEvent(serverID, s, true)
+	}
+	p.GetServerById(serverID).RouteSession(s, msg)
 }
 
 func (p *Gate) RegisterSessionMsgHandler(cb interface{}) {
@@ -76,6 +230,21 @@ func (p *Gate) RegisterServerHandler(cb interface{}) {
 	p.rpc.RegisterServerMsgHandler(cb)
 }
 
+func (p *Gate) SetConcurrent(msg proto.Message, limit int) {
+	p.rpc.SetConcurrent(msg, limit)
+}
+
+// 开启request去重，避免重试导致handler重复执行
+func (p *Gate) EnableDedup(ttl time.Duration) {
+	p.rpc.EnableDedup(ttl)
+}
+
+// 同时设置客户端消息和rpc消息的优先级
+func (p *Gate) SetPriority(msg proto.Message, pri natsrpc.Priority) {
+	p.networkMgr.SetPriority(msg, pri)
+	p.rpc.SetPriority(msg, pri)
+}
+
 func (p *Gate) GetServerById(serverID int32) engine.Server {
 	return p.rpc.GetServerById(serverID)
 }
@@ -84,12 +253,103 @@ func (p *Gate) GetSession(sesID int32) (natsrpc.Session, bool) {
 	return p.networkMgr.GetSession(sesID)
 }
 
+// gate本地的分组，后端通过engine.Session.JoinGroup加入的分组同样可以使用
+func (p *Gate) JoinGroup(group string, sesID int32) bool {
+	return p.networkMgr.JoinGroup(group, sesID)
+}
+
+func (p *Gate) LeaveGroup(group string, sesID int32) {
+	p.networkMgr.LeaveGroup(group, sesID)
+}
+
+// 只发送给本gate上的成员，发送给所有gate使用Multicast
+func (p *Gate) LocalMulticast(group string, msg proto.Message) error {
+	return p.networkMgr.Multicast(group, msg)
+}
+
+// 发送给所有gate上的分组成员
+func (p *Gate) Multicast(group string, msg proto.Message) error {
+	return p.rpc.Multicast(group, msg)
+}
+
+// 发送给所有gate的所有session，filter不为空时只发送给绑定属性全部相同的session
+func (p *Gate) Broadcast(msg proto.Message, filter map[string]string) error {
+	return p.rpc.Broadcast(msg, filter)
+}
+
+// 本gate广播时每秒最多写入的session数，小于等于0时不限制
+func (p *Gate) SetBroadcastRate(n int) {
+	p.networkMgr.SetBroadcastRate(n)
+}
+
+// 本gate广播队列满时丢弃的广播数，可以用于监控
+func (p *Gate) BroadcastDropped() int64 {
+	return p.networkMgr.BroadcastDropped()
+}
+
+// 断开session，reason不为空时客户端先收到rpcmsg.Kick
+func (p *Gate) CloseSession(sesID int32, reason string) bool {
+	return p.networkMgr.CloseSession(sesID, reason)
+}
+
+// 通过Session.BindUser绑定的用户
+func (p *Gate) GetSessionByUser(userID int64) (natsrpc.Session, bool) {
+	return p.networkMgr.GetSessionByUser(userID)
+}
+
+// 消息路由到serverID，后端的engine.Session可以获取绑定的用户和属性
 func (p *Gate) RouteSessionMsg(msg proto.Message, serverID int32) {
 	p.networkMgr.RegisterRawSessionMsgHandler(msg, func(s natsrpc.Session, msg proto.Message) {
-		p.GetServerById(serverID).RouteSession2Server(s.ID(), msg)
+		p.routeSession(s, serverID, msg)
+	})
+}
+
+// 按服务器类型路由，session第一次发送时按balance选择服务器并固定，服务器下线后重新选择
+// BalanceHash使用绑定的用户id作为key，没有绑定时使用session id
+func (p *Gate) RouteSessionMsgByType(msg proto.Message, serverType engine.ServerType, balance engine.Balance) {
+	p.networkMgr.RegisterRawSessionMsgHandler(msg, func(s natsrpc.Session, msg proto.Message) {
+		serverID, ok := p.pinServer(s, serverType, balance)
+		if !ok {
+			logger.DefaultLogger.Warn("no server for session", zap.Int32("serverType", int32(serverType)), zap.Int32("sesID", s.ID()))
+			return
+		}
+		p.routeSession(s, serverID, msg)
 	})
 }
 
+func (p *Gate) pinServer(s natsrpc.Session, serverType engine.ServerType, balance engine.Balance) (int32, bool) {
+	key := uint64(s.ID())
+	if uid := s.UserID(); uid != 0 {
+		key = uint64(uid)
+	}
+	return p.pins.pick(s.ID(), key, serverType, balance)
+}
+
+// 把session按类型路由的消息固定发送到serverID
+// session不存在、serverID不在线或类型不是serverType时返回false
+func (p *Gate) Rebind(sesID int32, serverType engine.ServerType, serverID int32) bool {
+	if _, ok := p.GetSession(sesID); !ok {
+		return false
+	}
+	if !p.pins.set(sesID, serverType, serverID) {
+		return false
+	}
+	// 设置期间session已断开
+	if _, ok := p.GetSession(sesID); !ok {
+		p.pins.remove(sesID)
+		return false
+	}
+	return true
+}
+
+// 后端发起的迁移，按to的类型替换固定的服务器
+func (p *Gate) rebind(sesID int32, from int32, to int32) {
+	typ, ok := p.rpc.ServerType(to)
+	if !ok || !p.Rebind(sesID, typ, to) {
+		logger.DefaultLogger.Warn("rebind failed", zap.Int32("sesID", sesID), zap.Int32("from", from), zap.Int32("to", to))
+	}
+}
+
 func (p *Gate) RegisterRawSessionMsgHandler(msg proto.Message, f func(s natsrpc.Session, message proto.Message)) {
 	p.networkMgr.RegisterRawSessionMsgHandler(msg, f)
 }
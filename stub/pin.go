@@ -0,0 +1,80 @@
+package stub
+
+import (
+	"sync"
+
+	"github.com/wwqdrh/natsrpc/engine"
+)
+
+// 服务发现，由engine.RPC实现
+type serverRegistry interface {
+	ServerType(serverID int32) (engine.ServerType, bool)
+	PickServerID(serverType engine.ServerType, balance engine.Balance, key uint64) (int32, bool)
+}
+
+// 按类型路由时session固定的服务器
+type pinTable struct {
+	reg serverRegistry
+
+	mu   sync.Mutex
+	pins map[int32]map[engine.ServerType]int32
+}
+
+func newPinTable(reg serverRegistry) *pinTable {
+	return &pinTable{
+		reg:  reg,
+		pins: make(map[int32]map[engine.ServerType]int32),
+	}
+}
+
+// 已固定且在线时返回固定的服务器，否则按balance重新选择并固定
+func (p *pinTable) pick(sesID int32, key uint64, serverType engine.ServerType, balance engine.Balance) (int32, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pins, ok := p.pins[sesID]
+	if !ok {
+		pins = make(map[engine.ServerType]int32)
+		p.pins[sesID] = pins
+	}
+	if id, ok := pins[serverType]; ok {
+		if _, alive := p.reg.ServerType(id); alive {
+			return id, true
+		}
+	}
+	id, ok := p.reg.PickServerID(serverType, balance, key)
+	if !ok {
+		delete(pins, serverType)
+		return 0, false
+	}
+	pins[serverType] = id
+	return id, true
+}
+
+// serverID不在线或类型不是serverType时返回false
+func (p *pinTable) set(sesID int32, serverType engine.ServerType, serverID int32) bool {
+	if typ, ok := p.reg.ServerType(serverID); !ok || typ != serverType {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pins, ok := p.pins[sesID]
+	if !ok {
+		pins = make(map[engine.ServerType]int32)
+		p.pins[sesID] = pins
+	}
+	pins[serverType] = serverID
+	return true
+}
+
+func (p *pinTable) get(sesID int32, serverType engine.ServerType) (int32, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok := p.pins[sesID][serverType]
+	return id, ok
+}
+
+func (p *pinTable) remove(sesID int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pins, sesID)
+}
@@ -0,0 +1,82 @@
+package stub
+
+import (
+	"testing"
+
+	"github.com/wwqdrh/natsrpc/engine"
+)
+
+// 只按类型记录在线的服务器，选择时返回第一个
+type fakeRegistry struct {
+	servers map[int32]engine.ServerType
+	order   []int32
+}
+
+func (p *fakeRegistry) ServerType(serverID int32) (engine.ServerType, bool) {
+	typ, ok := p.servers[serverID]
+	return typ, ok
+}
+
+func (p *fakeRegistry) PickServerID(serverType engine.ServerType, balance engine.Balance, key uint64) (int32, bool) {
+	for _, id := range p.order {
+		if typ, ok := p.servers[id]; ok && typ == serverType {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func TestPinTable(t *testing.T) {
+	reg := &fakeRegistry{
+		servers: map[int32]engine.ServerType{101: engine.Game, 102: engine.Game, 201: engine.Users},
+		order:   []int32{101, 102, 201},
+	}
+	pins := newPinTable(reg)
+
+	// 第一次选择后固定，之后即使选择结果变化也不改变
+	if id, ok := pins.pick(1, 1, engine.Game, engine.BalanceHash); !ok || id != 101 {
+		t.Fatalf("pick not equal: %d", id)
+	}
+	reg.order = []int32{102, 101, 201}
+	if id, _ := pins.pick(1, 1, engine.Game, engine.BalanceHash); id != 101 {
+		t.Errorf("pin changed: %d", id)
+	}
+
+	// 固定的服务器下线后重新选择
+	delete(reg.servers, 101)
+	if id, _ := pins.pick(1, 1, engine.Game, engine.BalanceHash); id != 102 {
+		t.Errorf("re-pick not equal: %d", id)
+	}
+	if id, ok := pins.get(1, engine.Game); !ok || id != 102 {
+		t.Errorf("pin not updated: %d", id)
+	}
+
+	// 没有可用的服务器时清除固定
+	delete(reg.servers, 102)
+	if _, ok := pins.pick(1, 1, engine.Game, engine.BalanceHash); ok {
+		t.Error("picked offline server")
+	}
+	if _, ok := pins.get(1, engine.Game); ok {
+		t.Error("pin not cleared")
+	}
+
+	// 迁移只接受在线且类型一致的服务器
+	reg.servers[101] = engine.Game
+	if pins.set(1, engine.Game, 201) {
+		t.Error("rebind to other type")
+	}
+	if pins.set(1, engine.Game, 999) {
+		t.Error("rebind to unknown server")
+	}
+	if !pins.set(1, engine.Game, 101) {
+		t.Error("rebind failed")
+	}
+	if id, _ := pins.pick(1, 1, engine.Game, engine.BalanceHash); id != 101 {
+		t.Errorf("rebind not used: %d", id)
+	}
+
+	pins.remove(1)
+	if _, ok := pins.get(1, engine.Game); ok {
+		t.Error("pins not removed")
+	}
+}
@@ -1,8 +1,13 @@
 package stub
 
 import (
+	"context"
+	"time"
+
 	"github.com/wwqdrh/natsrpc"
+	"github.com/wwqdrh/natsrpc/actor"
 	"github.com/wwqdrh/natsrpc/engine"
+	"google.golang.org/protobuf/proto"
 )
 
 type Server struct {
@@ -11,9 +16,10 @@ type Server struct {
 	serverID int32
 }
 
+// config.Shards大于1时session消息在多个协程中执行，见natsrpc.ShardedWorker
 func NewServer(serverID int32, config natsrpc.Config) (*Server, error) {
 	p := new(Server)
-	p.worker = natsrpc.NewWorker()
+	p.worker = config.NewWorker()
 	rpc, err := engine.NewRPC(serverID, p.worker, config.Nats)
 	if err != nil {
 		return nil, err
@@ -29,22 +35,76 @@ func (p *Server) Run() {
 	p.rpc.Run()
 }
 
+// 帧循环模式，帧率等配置见Config.Worker.Frame，tick和消息handler都在worker协程中执行
+func (p *Server) RunFrame(tick func(natsrpc.Frame)) {
+	p.worker.RunFrame(tick)
+	p.rpc.Run()
+}
+
+func (p *Server) Close() {
+	p.rpc.Close()
+	p.worker.Close()
+}
+
+// 停止接收rpc消息，执行完worker中剩余的任务后返回
+func (p *Server) Shutdown(ctx context.Context) error {
+	p.rpc.Close()
+	return p.worker.Shutdown(ctx)
+}
+
 func (p *Server) Worker() natsrpc.Worker {
 	return p.worker
 }
 
-func (p *Server) Post(f func()) {
-	p.worker.Post(f)
+func (p *Server) Post(f func()) error {
+	return p.worker.Post(f)
 }
 
 func (p *Server) RegisterRequestMsgHandler(cb interface{}) {
 	p.rpc.RegisterRequestMsgHandler(cb)
 }
 
+// 该消息的handler在协程池中并发执行，适合访问db等耗时操作，默认仍在worker中顺序执行
+func (p *Server) SetConcurrent(msg proto.Message, limit int) {
+	p.rpc.SetConcurrent(msg, limit)
+}
+
+// 开启request去重，避免重试导致handler重复执行
+func (p *Server) EnableDedup(ttl time.Duration) {
+	p.rpc.EnableDedup(ttl)
+}
+
+func (p *Server) SetPriority(msg proto.Message, pri natsrpc.Priority) {
+	p.rpc.SetPriority(msg, pri)
+}
+
+// 创建actor系统，其他服务器可以通过PID{ServerID: p.ID()}访问，需要在Run之前调用
+func (p *Server) NewActorSystem() *actor.System {
+	return actor.NewSystem(p.worker, p.rpc)
+}
+
 func (p *Server) GetServerById(serverID int32) engine.Server {
 	return p.rpc.GetServerById(serverID)
 }
 
+// 定期发布本服务器的类型和负载，gate可以按类型路由到本服务器，需要在Run之前调用
+func (p *Server) SetServerType(serverType engine.ServerType) {
+	p.rpc.SetServerType(serverType)
+}
+
+// 订阅服务发现后可以使用GetServerByType、PickServer，需要在Run之前调用
+func (p *Server) WatchServers() error {
+	return p.rpc.WatchServers()
+}
+
+func (p *Server) GetServerByType(serverType engine.ServerType) engine.Server {
+	return p.rpc.GetServerByType(serverType)
+}
+
+func (p *Server) PickServer(serverType engine.ServerType, balance engine.Balance, key uint64) engine.Server {
+	return p.rpc.PickServer(serverType, balance, key)
+}
+
 //
 //func (p *Server) RegisterServerMsg(msg proto.Message, f func(rpc.Server, proto.Message)) {
 //	p.rpc.RegisterServerMsg(msg, f)
@@ -58,6 +118,26 @@ func (p *Server) RegisterServerHandler(cb interface{}) {
 	p.rpc.RegisterServerMsgHandler(cb)
 }
 
+// 路由过消息的session第一次路由到本服务器、断开时调用，onClosed用来清理session的状态
+func (p *Server) RegisterSessionEvent(onOpened, onClosed func(engine.Session)) {
+	p.rpc.RegisterSessionEvent(onOpened, onClosed)
+}
+
+// 接收所有gate的所有session事件，如在线统计，需要在Run之前调用
+func (p *Server) SubscribeSessionEvents() error {
+	return p.rpc.SubscribeSessionEvents()
+}
+
+// 发送给所有gate上的分组成员，成员通过engine.Session.JoinGroup加入
+func (p *Server) Multicast(group string, msg proto.Message) error {
+	return p.rpc.Multicast(group, msg)
+}
+
+// 发送给所有gate的所有session，如全服公告、停服维护通知
+func (p *Server) Broadcast(msg proto.Message, filter map[string]string) error {
+	return p.rpc.Broadcast(msg, filter)
+}
+
 func (p *Server) ID() int32 {
 	return p.serverID
 }
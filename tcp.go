@@ -0,0 +1,278 @@
+package natsrpc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrConnClosed   = errors.New("conn: closed")
+	ErrConnSendFull = errors.New("conn: send buffer full")
+)
+
+const (
+	DefaultTCPMaxMsgLen = 1 << 20
+	tcpSendBuffer       = 256
+	tcpWriteWait        = 10 * time.Second
+)
+
+// 连接建立后调用OnNew，OnNew返回时连接已断开，再调用OnClose
+type ConnInvoker interface {
+	OnNew()
+	OnClose()
+}
+
+// tcp监听，每个包为4字节长度+数据，数据与websocket的消息相同，为4字节msgID+protobuf
+// 长度的字节序与Processor一致
+type TCPServer struct {
+	addr         string
+	tlsConf      *tls.Config
+	littleEndian bool
+	maxMsgLen    uint32
+	onInvoker    func(conn Conn) ConnInvoker
+
+	connid int32
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[*TCPConn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewTCPServer(addr string, littleEndian bool, invoker func(conn Conn) ConnInvoker) *TCPServer {
+	return &TCPServer{
+		addr:         addr,
+		littleEndian: littleEndian,
+		maxMsgLen:    DefaultTCPMaxMsgLen,
+		onInvoker:    invoker,
+		conns:        make(map[*TCPConn]struct{}),
+	}
+}
+
+// 需要在Start之前调用
+func (p *TCPServer) SetTLS(conf *tls.Config) {
+	p.tlsConf = conf
+}
+
+// 超过长度的包视为非法，直接断开连接
+func (p *TCPServer) SetMaxMsgLen(n uint32) {
+	p.maxMsgLen = n
+}
+
+func (p *TCPServer) Start() error {
+	ln, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		logger.DefaultLogger.Error("TCPServer listen failed", zap.String("addr", p.addr), zap.Error(err))
+		return err
+	}
+	if p.tlsConf != nil {
+		ln = tls.NewListener(ln, p.tlsConf)
+	}
+	p.ln = ln
+	go p.serve()
+	return nil
+}
+
+func (p *TCPServer) serve() {
+	var delay time.Duration
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// 文件描述符不足等临时错误，等待后重试
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				logger.DefaultLogger.Warn("TCPServer accept", zap.Error(err), zap.Duration("retry", delay))
+				time.Sleep(delay)
+				continue
+			}
+			return
+		}
+		delay = 0
+
+		tc := newTCPConn(conn, p.littleEndian, p.maxMsgLen)
+		tc.id = atomic.AddInt32(&p.connid, 1)
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.conns[tc] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+
+		go p.handle(tc)
+	}
+}
+
+func (p *TCPServer) handle(conn *TCPConn) {
+	defer p.wg.Done()
+
+	var invoker ConnInvoker
+	if p.onInvoker != nil {
+		invoker = p.onInvoker(conn)
+	}
+	if invoker != nil {
+		invoker.OnNew()
+	}
+	conn.Close()
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	if invoker != nil {
+		invoker.OnClose()
+	}
+}
+
+// 停止监听并断开所有连接
+func (p *TCPServer) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	if p.ln != nil {
+		p.ln.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *TCPServer) ListenAddr() *net.TCPAddr {
+	return p.ln.Addr().(*net.TCPAddr)
+}
+
+// 读在连接的协程中直接进行，写通过缓冲队列在单独的协程中进行，避免worker被慢客户端阻塞
+type TCPConn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	littleEndian bool
+	maxMsgLen    uint32
+	id           int32
+
+	mu        sync.Mutex
+	send      chan []byte
+	closeFlag bool
+}
+
+func newTCPConn(conn net.Conn, littleEndian bool, maxMsgLen uint32) *TCPConn {
+	p := &TCPConn{
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		littleEndian: littleEndian,
+		maxMsgLen:    maxMsgLen,
+		send:         make(chan []byte, tcpSendBuffer),
+	}
+	go p.writeLoop()
+	return p
+}
+
+func (p *TCPConn) ReadMsg() ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(p.reader, head[:]); err != nil {
+		return nil, err
+	}
+	var n uint32
+	if p.littleEndian {
+		n = binary.LittleEndian.Uint32(head[:])
+	} else {
+		n = binary.BigEndian.Uint32(head[:])
+	}
+	if n > p.maxMsgLen {
+		return nil, fmt.Errorf("tcp message too long: %d > %d", n, p.maxMsgLen)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 发送队列满时断开连接，由客户端重连
+func (p *TCPConn) WriteMsg(data []byte) error {
+	if uint32(len(data)) > p.maxMsgLen {
+		return fmt.Errorf("tcp message too long: %d > %d", len(data), p.maxMsgLen)
+	}
+	buf := make([]byte, 4+len(data))
+	if p.littleEndian {
+		binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	} else {
+		binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	}
+	copy(buf[4:], data)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closeFlag {
+		return ErrConnClosed
+	}
+	select {
+	case p.send <- buf:
+		return nil
+	default:
+		p.closeLocked()
+		return ErrConnSendFull
+	}
+}
+
+func (p *TCPConn) writeLoop() {
+	defer p.conn.Close()
+	for buf := range p.send {
+		p.conn.SetWriteDeadline(time.Now().Add(tcpWriteWait))
+		if _, err := p.conn.Write(buf); err != nil {
+			p.Close()
+			// 丢弃未发送的消息
+			for range p.send {
+			}
+			return
+		}
+	}
+}
+
+func (p *TCPConn) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *TCPConn) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+func (p *TCPConn) ID() int32 {
+	return p.id
+}
+
+// 发送完队列中的消息后断开
+func (p *TCPConn) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+}
+
+func (p *TCPConn) closeLocked() {
+	if p.closeFlag {
+		return
+	}
+	p.closeFlag = true
+	close(p.send)
+}
@@ -0,0 +1,95 @@
+package natsrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func writeTCPMsg(conn net.Conn, p *Processor, msg proto.Message) error {
+	data, err := p.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	_, err = conn.Write(buf)
+	return err
+}
+
+func readTCPMsg(conn net.Conn, p *Processor) (proto.Message, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(head[:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	return p.Unmarshal(data)
+}
+
+func TestMgrTCP(t *testing.T) {
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("", w)
+	mgr.ListenTCP("127.0.0.1:0")
+	mgr.ListenTCP("127.0.0.1:0")
+	closed := make(chan int32, 2)
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) { closed <- s.ID() })
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.StringValue) {
+		if _, ok := mgr.GetSession(s.ID()); !ok {
+			t.Errorf("session %d not found", s.ID())
+		}
+		s.SendMsg(wrapperspb.String(msg.Value + ":" + string(rune('0'+s.ID()))))
+	})
+	mgr.Run()
+	defer mgr.Close()
+
+	addrs := mgr.TCPListenAddrs()
+	if len(addrs) != 2 {
+		t.Errorf("listen addrs not equal: %v", addrs)
+		return
+	}
+	ids := map[string]bool{}
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if err := writeTCPMsg(conn, mgr.processor, wrapperspb.String("hello")); err != nil {
+			t.Error(err)
+			return
+		}
+		resp, err := readTCPMsg(conn, mgr.processor)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ids[resp.(*wrapperspb.StringValue).Value] = true
+		conn.Close()
+	}
+	// 两个监听共用session id
+	if !ids["hello:1"] || !ids["hello:2"] {
+		t.Errorf("session ids not equal: %v", ids)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Error("session not closed")
+			return
+		}
+	}
+}
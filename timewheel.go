@@ -0,0 +1,467 @@
+package natsrpc
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 分层时间轮，大量的buff、cd等定时器共用一个协程，到期后投递到worker中执行
+// 第0层256个槽，其余每层64个槽，tick为10ms时最大可以覆盖约7.7天，更长的定时器会在高层重复降级
+const (
+	wheelBits0   = 8
+	wheelBitsN   = 6
+	wheelLevels  = 4
+	wheelSlots0  = 1 << wheelBits0
+	wheelSlotsN  = 1 << wheelBitsN
+	wheelMaxSpan = 1 << (wheelBits0 + wheelBitsN*(wheelLevels-1))
+)
+
+const DefaultWheelTick = 10 * time.Millisecond
+
+const (
+	timerPending int32 = iota
+	timerPaused
+	timerFired
+	timerCancelled
+)
+
+type WheelTimer struct {
+	wheel  *TimerWheel
+	f      func()
+	pri    Priority
+	period uint64 //周期，单位tick，0表示只执行一次
+
+	// 以下字段由wheel.mu保护
+	expire    uint64
+	remaining uint64 //暂停时剩余的tick
+	state     int32
+	gen       uint64 //每次取消、重置、暂停时加1，已投递到worker的旧回调不会再执行
+	running   bool   //回调正在执行
+	runner    int64  //执行回调的协程id，回调中调用Cancel等时不等待自己
+
+	slot       *timerSlot
+	prev, next *WheelTimer
+}
+
+type timerSlot struct {
+	head, tail *WheelTimer
+}
+
+func (s *timerSlot) push(t *WheelTimer) {
+	t.slot = s
+	t.prev = s.tail
+	t.next = nil
+	if s.tail != nil {
+		s.tail.next = t
+	} else {
+		s.head = t
+	}
+	s.tail = t
+}
+
+func (s *timerSlot) remove(t *WheelTimer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		s.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	} else {
+		s.tail = t.prev
+	}
+	t.slot, t.prev, t.next = nil, nil, nil
+}
+
+// 取出槽中所有定时器，保持加入的顺序
+func (s *timerSlot) take() []*WheelTimer {
+	var ret []*WheelTimer
+	for t := s.head; t != nil; {
+		next := t.next
+		t.slot, t.prev, t.next = nil, nil, nil
+		ret = append(ret, t)
+		t = next
+	}
+	s.head, s.tail = nil, nil
+	return ret
+}
+
+type TimerWheel struct {
+	worker Worker
+	tick   time.Duration
+
+	mu     sync.Mutex
+	idle   *sync.Cond //回调执行完时通知，等待mu
+	levels [wheelLevels][]timerSlot
+	now    uint64 //当前tick
+	count  int
+
+	start  time.Time
+	closed int32
+	done   chan struct{}
+	closer io.Closer
+}
+
+// worker关闭时同时关闭时间轮
+type timerTracker interface {
+	track(c io.Closer) bool
+	untrack(c io.Closer)
+}
+
+type wheelCloser struct {
+	wheel *TimerWheel
+}
+
+func (p *wheelCloser) Close() error {
+	p.wheel.Close()
+	return nil
+}
+
+func NewTimerWheel(worker Worker, tick time.Duration) *TimerWheel {
+	if tick <= 0 {
+		tick = DefaultWheelTick
+	}
+	p := &TimerWheel{
+		worker: worker,
+		tick:   tick,
+		done:   make(chan struct{}),
+	}
+	p.idle = sync.NewCond(&p.mu)
+	p.levels[0] = make([]timerSlot, wheelSlots0)
+	for i := 1; i < wheelLevels; i++ {
+		p.levels[i] = make([]timerSlot, wheelSlotsN)
+	}
+	return p
+}
+
+func (p *TimerWheel) Run() {
+	if t, ok := p.worker.(timerTracker); ok {
+		p.closer = &wheelCloser{wheel: p}
+		if !t.track(p.closer) {
+			p.Close()
+			return
+		}
+	}
+	p.start = time.Now()
+	go func() {
+		ticker := time.NewTicker(p.tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				// 协程被延迟调度时一次推进多个tick
+				target := uint64(now.Sub(p.start) / p.tick)
+				p.advanceTo(target)
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// 停止后未触发的定时器都不会再执行
+func (p *TimerWheel) Close() {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return
+	}
+	close(p.done)
+	if t, ok := p.worker.(timerTracker); ok && p.closer != nil {
+		t.untrack(p.closer)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.levels {
+		for j := range p.levels[i] {
+			for _, t := range p.levels[i][j].take() {
+				t.state = timerCancelled
+				t.gen++
+			}
+		}
+	}
+	p.count = 0
+}
+
+func (p *TimerWheel) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count
+}
+
+func (p *TimerWheel) After(d time.Duration, f func()) *WheelTimer {
+	return p.AfterPriority(d, PriorityNormal, f)
+}
+
+func (p *TimerWheel) AfterPriority(d time.Duration, pri Priority, f func()) *WheelTimer {
+	t := &WheelTimer{wheel: p, f: f, pri: pri}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.schedule(t, p.ticks(d))
+	return t
+}
+
+// 周期定时器，取消前每隔d执行一次
+func (p *TimerWheel) Every(d time.Duration, f func()) *WheelTimer {
+	return p.EveryPriority(d, PriorityNormal, f)
+}
+
+func (p *TimerWheel) EveryPriority(d time.Duration, pri Priority, f func()) *WheelTimer {
+	t := &WheelTimer{wheel: p, f: f, pri: pri, period: p.ticks(d)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.schedule(t, t.period)
+	return t
+}
+
+func (p *TimerWheel) ticks(d time.Duration) uint64 {
+	n := uint64((d + p.tick - 1) / p.tick)
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// 需要持有mu
+func (p *TimerWheel) schedule(t *WheelTimer, ticks uint64) {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		t.state = timerCancelled
+		return
+	}
+	t.state = timerPending
+	t.expire = p.now + ticks
+	p.add(t)
+	p.count++
+}
+
+// 需要持有mu
+func (p *TimerWheel) unschedule(t *WheelTimer) {
+	if t.slot != nil {
+		t.slot.remove(t)
+		p.count--
+	}
+}
+
+// 根据到期时间放入对应层的槽中
+func (p *TimerWheel) add(t *WheelTimer) {
+	expire := t.expire
+	delta := expire - p.now
+	if delta >= wheelMaxSpan {
+		// 超过时间轮范围，先放在最高层最远的槽，降级时重新计算
+		expire = p.now + wheelMaxSpan - 1
+		delta = wheelMaxSpan - 1
+	}
+
+	if delta < wheelSlots0 {
+		p.levels[0][expire&(wheelSlots0-1)].push(t)
+		return
+	}
+	for level := 1; level < wheelLevels; level++ {
+		shift := uint(wheelBits0 + wheelBitsN*(level-1))
+		if delta < 1<<(shift+wheelBitsN) || level == wheelLevels-1 {
+			p.levels[level][(expire>>shift)&(wheelSlotsN-1)].push(t)
+			return
+		}
+	}
+}
+
+func (p *TimerWheel) advanceTo(target uint64) {
+	for {
+		p.mu.Lock()
+		if p.now >= target || atomic.LoadInt32(&p.closed) != 0 {
+			p.mu.Unlock()
+			return
+		}
+		expired := p.advance()
+		p.mu.Unlock()
+
+		for _, v := range expired {
+			p.fire(v.t, v.gen)
+		}
+	}
+}
+
+type expiredTimer struct {
+	t   *WheelTimer
+	gen uint64
+}
+
+// 推进一个tick，需要持有mu
+func (p *TimerWheel) advance() []expiredTimer {
+	p.now++
+
+	// 低层转完一圈时把高层对应槽的定时器降级
+	if p.now&(wheelSlots0-1) == 0 {
+		for level := 1; level < wheelLevels; level++ {
+			shift := uint(wheelBits0 + wheelBitsN*(level-1))
+			idx := (p.now >> shift) & (wheelSlotsN - 1)
+			for _, t := range p.levels[level][idx].take() {
+				p.add(t)
+			}
+			if idx != 0 {
+				break
+			}
+		}
+	}
+
+	var expired []expiredTimer
+	for _, t := range p.levels[0][p.now&(wheelSlots0-1)].take() {
+		if t.expire > p.now {
+			p.add(t)
+			continue
+		}
+		expired = append(expired, expiredTimer{t: t, gen: t.gen})
+		if t.period > 0 {
+			t.expire = p.now + t.period
+			p.add(t)
+		} else {
+			p.count--
+		}
+	}
+	return expired
+}
+
+func (p *TimerWheel) fire(t *WheelTimer, gen uint64) {
+	err := p.worker.PostTask(Task{
+		Source:   SourceTimer,
+		Priority: t.pri,
+		F: func() {
+			if !t.begin(gen) {
+				return
+			}
+			defer t.end()
+			t.f()
+		},
+	})
+	if err == ErrWorkerClosed {
+		p.Close()
+	}
+}
+
+// 在worker中执行前检查，已取消、重置或暂停的不执行
+func (t *WheelTimer) begin(gen uint64) bool {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.gen != gen || t.state != timerPending {
+		return false
+	}
+	if t.period == 0 {
+		t.state = timerFired
+	}
+	t.running = true
+	t.runner = goroutineID()
+	return true
+}
+
+func (t *WheelTimer) end() {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.running = false
+	p.idle.Broadcast()
+}
+
+// 等待正在执行的回调结束，需要持有mu，在回调中调用时不等待
+func (t *WheelTimer) wait() {
+	if !t.running || t.runner == goroutineID() {
+		return
+	}
+	for t.running {
+		t.wheel.idle.Wait()
+	}
+}
+
+// 取消定时器，返回后回调不会再执行
+// 在其他协程中调用时等待正在执行的回调结束，在回调中调用时不等待，回调中不能等待其他协程取消该定时器
+func (t *WheelTimer) Cancel() bool {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state == timerFired || t.state == timerCancelled {
+		t.wait()
+		return false
+	}
+	p.unschedule(t)
+	t.state = timerCancelled
+	t.gen++
+	t.wait()
+	return true
+}
+
+// 从现在开始重新计时，已到期但还未执行的回调不会再执行，与Cancel一样等待正在执行的回调
+func (t *WheelTimer) Reschedule(d time.Duration) bool {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state == timerCancelled {
+		return false
+	}
+	p.unschedule(t)
+	t.gen++
+	p.schedule(t, p.ticks(d))
+	t.wait()
+	return t.state == timerPending
+}
+
+// 暂停计时，Resume后继续计时剩余的时间，与Cancel一样等待正在执行的回调
+func (t *WheelTimer) Pause() bool {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state != timerPending {
+		return false
+	}
+	p.unschedule(t)
+	t.gen++
+	t.state = timerPaused
+	t.remaining = 1
+	if t.expire > p.now {
+		t.remaining = t.expire - p.now
+	}
+	t.wait()
+	return true
+}
+
+func (t *WheelTimer) Resume() bool {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.state != timerPaused {
+		return false
+	}
+	p.schedule(t, t.remaining)
+	return t.state == timerPending
+}
+
+// 距离下次执行的剩余时间
+func (t *WheelTimer) Remaining() time.Duration {
+	p := t.wheel
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch t.state {
+	case timerPaused:
+		return time.Duration(t.remaining) * p.tick
+	case timerPending:
+		if t.expire > p.now {
+			return time.Duration(t.expire-p.now) * p.tick
+		}
+	}
+	return 0
+}
+
+// 当前协程id，只用于判断是否在回调中调用
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
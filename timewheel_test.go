@@ -0,0 +1,120 @@
+package natsrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimerWheelCascade(t *testing.T) {
+	w := NewWorker()
+	wheel := NewTimerWheel(w, time.Millisecond)
+
+	delays := []int{1, 255, 256, 300, 16383, 16384, 20000, 1 << 20}
+	for _, d := range delays {
+		wheel.After(time.Duration(d)*time.Millisecond, func() {})
+	}
+
+	// worker没有运行，到期的定时器都在队列中
+	for i, d := range delays {
+		wheel.advanceTo(uint64(d - 1))
+		if w.Len() != i {
+			t.Errorf("timer %d fired early, worker len %d", d, w.Len())
+			return
+		}
+		wheel.advanceTo(uint64(d))
+		if w.Len() != i+1 {
+			t.Errorf("timer %d not fired, worker len %d", d, w.Len())
+			return
+		}
+	}
+	if wheel.Len() != 0 {
+		t.Errorf("wheel len not equal: %d", wheel.Len())
+	}
+}
+
+func TestTimerWheelCancel(t *testing.T) {
+	w := NewWorker()
+	wheel := NewTimerWheel(w, time.Millisecond)
+
+	var ret []string
+	cancelled := wheel.After(5*time.Millisecond, func() { ret = append(ret, "cancelled") })
+	queued := wheel.After(5*time.Millisecond, func() { ret = append(ret, "queued") })
+	paused := wheel.After(10*time.Millisecond, func() { ret = append(ret, "paused") })
+	wheel.Every(4*time.Millisecond, func() { ret = append(ret, "every") })
+
+	cancelled.Cancel()
+	wheel.advanceTo(5)
+	// 已投递到worker但还未执行，取消后不会执行
+	queued.Cancel()
+	paused.Pause()
+	if paused.Remaining() != 5*time.Millisecond {
+		t.Errorf("paused remaining not equal: %v", paused.Remaining())
+		return
+	}
+	wheel.advanceTo(20)
+	paused.Resume()
+	wheel.advanceTo(25)
+
+	w.Run()
+	w.Shutdown(context.Background())
+
+	expect := []string{"every", "every", "every", "every", "every", "every", "paused"}
+	if len(ret) != len(expect) {
+		t.Errorf("result not equal: %v", ret)
+		return
+	}
+	for i := range expect {
+		if ret[i] != expect[i] {
+			t.Errorf("result not equal: %v", ret)
+			return
+		}
+	}
+}
+
+// 其他协程中Cancel等待正在执行的回调结束，回调中Cancel自己不等待
+func TestTimerWheelCancelWait(t *testing.T) {
+	w := NewWorker()
+	wheel := NewTimerWheel(w, time.Millisecond)
+	w.Run()
+	defer w.Close()
+
+	var runs, finished int32
+	started := make(chan struct{}, 1)
+	every := wheel.Every(time.Millisecond, func() {
+		atomic.AddInt32(&runs, 1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&finished, 1)
+	})
+	wheel.advanceTo(1)
+	<-started
+	if !every.Cancel() {
+		t.Error("cancel return false")
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Errorf("cancel returned while callback running: %d", finished)
+	}
+	wheel.advanceTo(5)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != 1 {
+		t.Errorf("callback run after cancel: %d", runs)
+	}
+
+	done := make(chan struct{})
+	var self *WheelTimer
+	self = wheel.Every(time.Millisecond, func() {
+		self.Cancel()
+		close(done)
+	})
+	wheel.advanceTo(6)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cancel in callback blocked")
+	}
+}
@@ -0,0 +1,181 @@
+package natsrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+var ErrTLSConfig = errors.New("tls: invalid config")
+
+const DefaultCertReloadInterval = time.Minute
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// 1.0、1.1、1.2、1.3，默认1.2
+	MinVersion string `json:"min_version"`
+	// 设置后校验客户端证书，ClientAuthOptional为true时客户端可以不提供证书
+	ClientCAFile       string `json:"client_ca_file"`
+	ClientAuthOptional bool   `json:"client_auth_optional"`
+	// 定期检查证书文件的修改时间，变化时重新加载，已建立的连接不受影响，小于0时不检查
+	ReloadInterval time.Duration `json:"reload_interval"`
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// 生成tls.Config，证书通过GetCertificate获取，返回的CertReloader需要在不使用时Close
+func (p *TLSConfig) Build() (*tls.Config, *CertReloader, error) {
+	if p.CertFile == "" || p.KeyFile == "" {
+		return nil, nil, fmt.Errorf("%w: cert_file and key_file required", ErrTLSConfig)
+	}
+	version, ok := tlsVersions[p.MinVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown min_version %q", ErrTLSConfig, p.MinVersion)
+	}
+
+	reloader, err := NewCertReloader(p.CertFile, p.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     version,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if p.ClientCAFile != "" {
+		pem, err := os.ReadFile(p.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("%w: no certificate in %s", ErrTLSConfig, p.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if p.ClientAuthOptional {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	interval := p.ReloadInterval
+	if interval == 0 {
+		interval = DefaultCertReloadInterval
+	}
+	if interval > 0 {
+		reloader.Watch(interval)
+	}
+	return conf, reloader, nil
+}
+
+// 证书热更新，新的握手使用最新加载的证书
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	once sync.Once
+	done chan struct{}
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	p := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		done:     make(chan struct{}),
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// 重新加载证书，失败时继续使用之前的证书，可以在收到SIGHUP时调用
+func (p *CertReloader) Reload() error {
+	modTime, err := p.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.cert = &cert
+	p.modTime = modTime
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *CertReloader) latestModTime() (time.Time, error) {
+	var ret time.Time
+	for _, name := range []string{p.certFile, p.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(ret) {
+			ret = info.ModTime()
+		}
+	}
+	return ret, nil
+}
+
+func (p *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert, nil
+}
+
+// 文件修改时间变化时重新加载
+func (p *CertReloader) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-p.done:
+				return
+			}
+			modTime, err := p.latestModTime()
+			if err != nil {
+				logger.DefaultLogger.Warn("cert reload stat", zap.Error(err))
+				continue
+			}
+			p.mu.RLock()
+			changed := !modTime.Equal(p.modTime)
+			p.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := p.Reload(); err != nil {
+				logger.DefaultLogger.Error("cert reload failed", zap.String("cert", p.certFile), zap.Error(err))
+				continue
+			}
+			logger.DefaultLogger.Info("cert reloaded", zap.String("cert", p.certFile))
+		}
+	}()
+}
+
+func (p *CertReloader) Close() {
+	p.once.Do(func() {
+		close(p.done)
+	})
+}
@@ -0,0 +1,206 @@
+package natsrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 生成自签名证书，serial用于区分重新加载前后的证书
+func writeTestCert(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestMgrTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, 1)
+
+	w := NewWorker()
+	w.Run()
+	defer w.Shutdown(context.Background())
+
+	mgr := NewMgr("127.0.0.1:0", w)
+	mgr.SetTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3", ReloadInterval: -1})
+	mgr.RegisterEvent(func(s Session) {}, func(s Session) {})
+	mgr.RegisterSessionMsgHandler(func(s Session, msg *wrapperspb.StringValue) {
+		s.SendMsg(msg)
+	})
+	if err := mgr.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	dial := func() (*websocket.Conn, *big.Int, error) {
+		dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		conn, _, err := dialer.Dial("wss://"+mgr.ListenAddr().String(), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		state := conn.UnderlyingConn().(*tls.Conn).ConnectionState()
+		return conn, state.PeerCertificates[0].SerialNumber, nil
+	}
+
+	conn, serial, err := dial()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := mgr.processor.Marshal(wrapperspb.String("hello"))
+	conn.WriteMessage(websocket.BinaryMessage, data)
+	_, resp, err := conn.ReadMessage()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if msg, err := mgr.processor.Unmarshal(resp); err != nil || msg.(*wrapperspb.StringValue).Value != "hello" {
+		t.Errorf("resp not equal: %v %v", msg, err)
+	}
+	conn.Close()
+	if serial.Int64() != 1 {
+		t.Errorf("serial not equal: %v", serial)
+	}
+
+	// 重新加载后新连接使用新证书
+	writeTestCert(t, dir, 2)
+	if err := mgr.ReloadCert(); err != nil {
+		t.Error(err)
+		return
+	}
+	conn, serial, err = dial()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	if serial.Int64() != 2 {
+		t.Errorf("reload serial not equal: %v", serial)
+	}
+
+	// 低于最低版本的握手失败
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}}
+	if _, _, err := dialer.Dial("wss://"+mgr.ListenAddr().String(), nil); err == nil {
+		t.Error("tls1.2 handshake should fail")
+	}
+}
+
+func TestTLSClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, 1)
+
+	conf, reloader, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile}).Build()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reloader.Close()
+	if conf.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("client auth not equal: %v", conf.ClientAuth)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", conf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	cert, _ := tls.LoadX509KeyPair(certFile, keyFile)
+	for _, c := range []struct {
+		certs []tls.Certificate
+		ok    bool
+	}{{nil, false}, {[]tls.Certificate{cert}, true}} {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: c.certs})
+		if err == nil {
+			// tls1.3中服务器对客户端证书的校验结果在第一次读时返回
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+			if err != nil && err.Error() == "EOF" {
+				err = nil
+			}
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("client cert %v result not equal: %v", c.certs != nil, err)
+		}
+	}
+
+	if _, _, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "2.0"}).Build(); err == nil {
+		t.Error("unknown min version should fail")
+	}
+}
+
+// 证书错误或端口被占用时Run返回错误，不会启动任何监听
+func TestMgrRunError(t *testing.T) {
+	w := NewWorker()
+	mgr := NewMgr("127.0.0.1:0", w)
+	mgr.SetTLS(TLSConfig{CertFile: filepath.Join(t.TempDir(), "missing.pem"), KeyFile: "missing.key"})
+	if err := mgr.Run(); err == nil {
+		t.Error("expect tls error")
+	}
+	if mgr.ListenAddr() != nil {
+		t.Error("ws listening after tls error")
+	}
+	mgr.Close()
+
+	mgr = NewMgr("127.0.0.1:0", w)
+	if err := mgr.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+	busy := NewMgr("127.0.0.1:0", w)
+	busy.ListenTCP(mgr.ListenAddr().String())
+	if err := busy.Run(); err == nil {
+		t.Error("expect listen error")
+	}
+	busy.Close()
+}
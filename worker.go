@@ -1,8 +1,11 @@
 package natsrpc
 
 import (
+	"context"
+	"errors"
 	"io"
 	"runtime/debug"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -10,27 +13,150 @@ import (
 	"go.uber.org/zap"
 )
 
+var (
+	ErrWorkerClosed  = errors.New("worker: closed")
+	ErrWorkerFull    = errors.New("worker: queue full")
+	ErrWorkerTimeout = errors.New("worker: post timeout")
+)
+
+// 队列满时Post的处理策略
+type OverflowPolicy int
+
+const (
+	OverflowBlock        OverflowPolicy = iota //阻塞直到有空位
+	OverflowBlockTimeout                       //阻塞，超时返回ErrWorkerTimeout
+	OverflowDropNewest                         //丢弃当前任务
+	OverflowDropOldest                         //丢弃队列中最早的任务
+	OverflowError                              //不阻塞，返回ErrWorkerFull
+)
+
+const (
+	DefaultQueueSize   = 10240
+	DefaultPostTimeout = time.Second
+)
+
+type WorkerConfig struct {
+	QueueSize int            `json:"queue_size"`
+	Overflow  OverflowPolicy `json:"overflow"`
+	Timeout   time.Duration  `json:"timeout"`   //OverflowBlockTimeout使用，默认1s
+	SlowTask  time.Duration  `json:"slow_task"` //执行时间超过该值时打印日志，0不打印
+	Schedule  Schedule       `json:"schedule"`
+	Weights   [3]int         `json:"weights"` //ScheduleWeighted使用，依次为high、normal、low，默认8:4:1
+	Frame     FrameConfig    `json:"frame"`   //RunFrame使用
+
+	// 每个任务执行完后调用，可以接入prometheus等监控，在worker协程中执行
+	Observer func(source string, wait, run time.Duration) `json:"-"`
+}
+
+// 任务优先级，每个优先级一个队列
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1 //ping、关服、gm等控制消息
+)
+
+// 多个优先级队列都有任务时的调度方式
+type Schedule int
+
+const (
+	ScheduleStrict   Schedule = iota //总是先执行高优先级的任务
+	ScheduleWeighted                 //按权重轮流执行，避免低优先级饿死
+)
+
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+	laneNum
+)
+
+var defaultWeights = [laneNum]int{8, 4, 1}
+
+func laneOf(pri Priority) int {
+	switch {
+	case pri > PriorityNormal:
+		return laneHigh
+	case pri < PriorityNormal:
+		return laneLow
+	}
+	return laneNormal
+}
+
+// 任务来源，用于统计排队时间、执行时间和慢任务日志
+const (
+	SourceDefault = "post"
+	SourceTimer   = "timer"
+	SourceTicker  = "ticker"
+	SourceCron    = "cron"
+)
+
+type Task struct {
+	Key      uint32 //分片key
+	Source   string //来源，如ws:pb.ReqHello、rpc:pb.ReqCall、timer
+	Priority Priority
+	Ctx      context.Context //不为nil时，出队时已结束的任务不再执行
+	F        func()
+	OnDrop   func() //队列满被丢弃、ctx已结束不再执行、worker关闭时没有执行时调用，可以为nil
+}
+
+type task struct {
+	Task
+	postAt time.Time
+}
+
+func (t task) dropped() {
+	if t.OnDrop != nil {
+		t.OnDrop()
+	}
+}
+
 type Worker interface {
-	Post(f func())
+	Post(f func()) error
+	// 相同key的任务保证顺序执行，Work只有一个协程，等同于Post
+	// ShardedWorker中不同key的任务以及与Post的任务之间可能并行
+	PostKey(key uint32, f func()) error
+	PostTask(t Task) error
+	PostPriority(pri Priority, f func()) error
+	// 出队时ctx已结束的任务不执行，传给f的ctx在worker关闭时也会结束
+	PostCtx(ctx context.Context, f func(ctx context.Context)) error
+	// worker关闭时结束
+	Context() context.Context
 	Run()
+	// 帧循环模式，固定帧率执行tick，两帧之间处理消息
+	// 只有Work保证tick与所有消息在同一个协程执行，ShardedWorker中tick与其他分片的消息并行
+	RunFrame(tick func(Frame))
+	// 立即停止，丢弃队列中的任务
 	Close()
+	// 拒绝新任务，执行完队列中的任务或ctx超时后返回，不能在worker协程中调用
+	// 返回时worker协程已经退出，之后不会再执行任务
+	Shutdown(ctx context.Context) error
 	AfterPost(duration time.Duration, f func()) *time.Timer
+	AfterPostPriority(duration time.Duration, pri Priority, f func()) *time.Timer
 	NewTicker(d time.Duration, f func()) io.Closer
+	NewTickerPriority(d time.Duration, pri Priority, f func()) io.Closer
 	Len() int
+	// 按来源统计的排队时间和执行时间
+	Stats() map[string]TaskStat
+	FrameStats() FrameStat
 }
 
 type workTicker struct {
 	done     chan struct{}
-	worker   Worker
+	once     sync.Once
+	worker   *Work
 	duration time.Duration
+	pri      Priority
 	f        func()
 }
 
-func newWorkTicker(worker Worker, d time.Duration, f func()) *workTicker {
+func newWorkTicker(worker *Work, d time.Duration, pri Priority, f func()) *workTicker {
 	return &workTicker{
 		done:     make(chan struct{}, 1),
 		worker:   worker,
 		duration: d,
+		pri:      pri,
 		f:        f,
 	}
 }
@@ -42,7 +168,7 @@ func (p *workTicker) run() {
 		for {
 			select {
 			case <-ticker.C:
-				p.worker.Post(p.f)
+				p.worker.PostTask(Task{Source: SourceTicker, Priority: p.pri, F: p.f})
 			case <-p.done:
 				return
 			}
@@ -51,58 +177,373 @@ func (p *workTicker) run() {
 }
 
 func (p *workTicker) Close() error {
-	close(p.done)
+	p.once.Do(func() {
+		close(p.done)
+		p.worker.untrack(p)
+	})
 	return nil
 }
 
 type Work struct {
-	funChan chan func()
+	lanes   [laneNum]chan task
+	credits [laneNum]int //ScheduleWeighted剩余的执行次数
+	conf    WorkerConfig
+	stats   *workStats
+
+	frameStats frameStats
+	now        func() time.Time //帧循环使用的时钟，测试时替换
+
+	// Post持有读锁，关闭时加写锁等待进行中的Post结束，保证关闭后不会再有任务入队
+	postMu   sync.RWMutex
+	closed   int32
+	started  int32
+	quit     chan struct{} //唤醒阻塞中的Post
+	stop     chan struct{} //通知run协程退出
+	exit     chan struct{} //run协程已退出
+	drainCtx context.Context
+	drainErr error //drain超时时为drainCtx.Err()，exit关闭后读取
+	ctx      context.Context
+	cancel   context.CancelFunc
 
-	closed int32
+	timerMu sync.Mutex
+	tickers map[io.Closer]struct{}
+	timers  map[*time.Timer]struct{}
 }
 
 func NewWorker() Worker {
+	return NewWorkerWithConfig(WorkerConfig{})
+}
+
+func NewWorkerWithConfig(conf WorkerConfig) Worker {
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = DefaultQueueSize
+	}
+	if conf.Overflow == OverflowBlockTimeout && conf.Timeout <= 0 {
+		conf.Timeout = DefaultPostTimeout
+	}
 	p := new(Work)
-	p.funChan = make(chan func(), 10240)
+	if conf.Weights == [laneNum]int{} {
+		conf.Weights = defaultWeights
+	}
+	p.conf = conf
+	for i := range p.lanes {
+		p.lanes[i] = make(chan task, conf.QueueSize)
+	}
+	p.credits = conf.Weights
+	p.stats = newWorkStats()
+	p.now = time.Now
+	p.quit = make(chan struct{})
+	p.stop = make(chan struct{})
+	p.exit = make(chan struct{})
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.tickers = make(map[io.Closer]struct{})
+	p.timers = make(map[*time.Timer]struct{})
 	return p
 }
 
-func (p *Work) Post(f func()) {
-	if atomic.LoadInt32(&p.closed) == 0 {
-		p.funChan <- f
+func (p *Work) Post(f func()) error {
+	return p.PostTask(Task{Source: SourceDefault, F: f})
+}
+
+func (p *Work) PostKey(key uint32, f func()) error {
+	return p.PostTask(Task{Key: key, Source: SourceDefault, F: f})
+}
+
+func (p *Work) PostPriority(pri Priority, f func()) error {
+	return p.PostTask(Task{Source: SourceDefault, Priority: pri, F: f})
+}
+
+func (p *Work) PostCtx(ctx context.Context, f func(ctx context.Context)) error {
+	return p.PostTask(Task{Source: SourceDefault, Ctx: ctx, F: func() {
+		taskCtx, cancel := p.taskContext(ctx)
+		defer cancel()
+		f(taskCtx)
+	}})
+}
+
+// 任务的ctx和worker的ctx任意一个结束时结束
+func (p *Work) taskContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Done() == nil {
+		return p.ctx, func() {}
+	}
+	taskCtx, cancel := context.WithCancel(ctx)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			cancel()
+		case <-taskCtx.Done():
+		case <-stop:
+		}
+	}()
+	return taskCtx, func() {
+		close(stop)
+		cancel()
+	}
+}
+
+func (p *Work) Context() context.Context {
+	return p.ctx
+}
+
+func (p *Work) PostTask(t Task) error {
+	f := task{Task: t, postAt: time.Now()}
+	ch := p.lanes[laneOf(t.Priority)]
+	p.postMu.RLock()
+	defer p.postMu.RUnlock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return ErrWorkerClosed
+	}
+
+	switch p.conf.Overflow {
+	case OverflowBlockTimeout:
+		select {
+		case ch <- f:
+			return nil
+		default:
+		}
+		timer := time.NewTimer(p.conf.Timeout)
+		defer timer.Stop()
+		select {
+		case ch <- f:
+			return nil
+		case <-timer.C:
+			return ErrWorkerTimeout
+		case <-p.quit:
+			return ErrWorkerClosed
+		}
+	case OverflowDropNewest:
+		select {
+		case ch <- f:
+		default:
+			logger.DefaultLogger.Warn("worker full,discard newest", zap.Int("workerLen", len(ch)))
+			f.dropped()
+		}
+		return nil
+	case OverflowDropOldest:
+		for {
+			select {
+			case ch <- f:
+				return nil
+			default:
+			}
+			select {
+			case old := <-ch:
+				logger.DefaultLogger.Warn("worker full,discard oldest", zap.Int("workerLen", len(ch)))
+				old.dropped()
+			default:
+			}
+		}
+	case OverflowError:
+		select {
+		case ch <- f:
+			return nil
+		default:
+			return ErrWorkerFull
+		}
+	default:
+		select {
+		case ch <- f:
+			return nil
+		case <-p.quit:
+			return ErrWorkerClosed
+		}
 	}
 }
 
 func (p *Work) TryPost(f func(), maxLen int) {
-	if maxLen != 0 && len(p.funChan) > maxLen {
-		logger.DefaultLogger.Warn("tryPost over maxLen", zap.Int("maxLen", maxLen), zap.Int("workerLen", len(p.funChan)))
+	p.postMu.RLock()
+	defer p.postMu.RUnlock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return
+	}
+
+	if maxLen != 0 && p.Len() > maxLen {
+		logger.DefaultLogger.Warn("tryPost over maxLen", zap.Int("maxLen", maxLen), zap.Int("workerLen", p.Len()))
 		return
 	}
 
 	select {
-	case p.funChan <- f:
+	case p.lanes[laneNormal] <- task{Task: Task{Source: SourceDefault, F: f}, postAt: time.Now()}:
 	default:
-		logger.DefaultLogger.Warn("worker tryPost,discard", zap.Int("workerLen", len(p.funChan)))
+		logger.DefaultLogger.Warn("worker tryPost,discard", zap.Int("workerLen", p.Len()))
 	}
 }
 
 func (p *Work) Run() {
+	if !atomic.CompareAndSwapInt32(&p.started, 0, 1) {
+		return
+	}
 	go func() {
-		for f := range p.funChan {
-			p.protectedFun(f)
+		defer close(p.exit)
+		for {
+			// 关闭后不再取新任务，剩余的任务交给drain
+			if p.stopping() {
+				p.drain()
+				return
+			}
+			if t, ok := p.next(); ok {
+				p.exec(t)
+				continue
+			}
+			select {
+			case t := <-p.lanes[laneHigh]:
+				p.exec(t)
+			case t := <-p.lanes[laneNormal]:
+				p.exec(t)
+			case t := <-p.lanes[laneLow]:
+				p.exec(t)
+			case <-p.stop:
+				p.drain()
+				return
+			}
 		}
 	}()
 }
 
+func (p *Work) stopping() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// 按调度方式取出下一个任务，没有任务时返回false
+func (p *Work) next() (task, bool) {
+	if p.conf.Schedule == ScheduleWeighted {
+		return p.nextWeighted()
+	}
+	for _, ch := range p.lanes {
+		select {
+		case t := <-ch:
+			return t, true
+		default:
+		}
+	}
+	return task{}, false
+}
+
+// 每个队列每轮最多执行weight次，所有队列次数用完或有次数的队列为空时开始新的一轮
+func (p *Work) nextWeighted() (task, bool) {
+	for round := 0; round < 2; round++ {
+		for i, ch := range p.lanes {
+			if p.credits[i] <= 0 {
+				continue
+			}
+			select {
+			case t := <-ch:
+				p.credits[i]--
+				return t, true
+			default:
+			}
+		}
+		p.credits = p.conf.Weights
+	}
+	return task{}, false
+}
+
+// 关闭时执行队列中剩余的任务，drainCtx为nil或超时后剩余的任务调用OnDrop丢弃
+func (p *Work) drain() {
+	if p.drainCtx == nil {
+		p.discard()
+		return
+	}
+	for {
+		select {
+		case <-p.drainCtx.Done():
+			logger.DefaultLogger.Warn("worker drain timeout,discard", zap.Int("workerLen", p.Len()))
+			p.drainErr = p.drainCtx.Err()
+			p.discard()
+			return
+		default:
+		}
+		t, ok := p.next()
+		if !ok {
+			return
+		}
+		p.exec(t)
+	}
+}
+
+func (p *Work) discard() {
+	for _, ch := range p.lanes {
+		for {
+			select {
+			case t := <-ch:
+				t.dropped()
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
 func (p *Work) Close() {
-	if atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
-		close(p.funChan)
+	p.shutdown(nil)
+}
+
+func (p *Work) Shutdown(ctx context.Context) error {
+	p.shutdown(ctx)
+	// drain在两个任务之间检查ctx，等待协程退出保证返回后不再执行任务
+	<-p.exit
+	return p.drainErr
+}
+
+func (p *Work) shutdown(drainCtx context.Context) {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return
+	}
+	close(p.quit)
+	p.postMu.Lock()
+	p.postMu.Unlock()
+
+	p.stopTimers()
+	p.cancel()
+	p.drainCtx = drainCtx
+	close(p.stop)
+	// 没有Run过时没有协程处理队列，直接丢弃，之后不能再Run
+	if atomic.CompareAndSwapInt32(&p.started, 0, 1) {
+		p.discard()
+		close(p.exit)
 	}
 }
 
 func (p *Work) Len() int {
-	return len(p.funChan)
+	n := 0
+	for _, ch := range p.lanes {
+		n += len(ch)
+	}
+	return n
+}
+
+func (p *Work) Stats() map[string]TaskStat {
+	return p.stats.snapshot()
 }
+
+func (p *Work) exec(t task) {
+	if t.Ctx != nil && t.Ctx.Err() != nil {
+		p.stats.skip(t.Source)
+		t.dropped()
+		return
+	}
+
+	start := time.Now()
+	p.protectedFun(t.F)
+	run := time.Since(start)
+	wait := start.Sub(t.postAt)
+
+	p.stats.record(t.Source, wait, run)
+	if p.conf.SlowTask > 0 && run >= p.conf.SlowTask {
+		logger.DefaultLogger.Warn("worker slow task", zap.String("source", t.Source), zap.Duration("run", run), zap.Duration("wait", wait), zap.Int("workerLen", p.Len()))
+	}
+	if p.conf.Observer != nil {
+		p.conf.Observer(t.Source, wait, run)
+	}
+}
+
 func (p *Work) protectedFun(callback func()) {
 	//TODO 每个函数都包装了defer，性能怎样？
 	defer func() {
@@ -114,17 +555,75 @@ func (p *Work) protectedFun(callback func()) {
 }
 
 func (p *Work) AfterPost(d time.Duration, f func()) *time.Timer {
-	return time.AfterFunc(d, func() {
-		p.Post(f)
+	return p.AfterPostPriority(d, PriorityNormal, f)
+}
+
+func (p *Work) AfterPostPriority(d time.Duration, pri Priority, f func()) *time.Timer {
+	p.timerMu.Lock()
+	defer p.timerMu.Unlock()
+
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		p.timerMu.Lock()
+		delete(p.timers, t)
+		p.timerMu.Unlock()
+		p.PostTask(Task{Source: SourceTimer, Priority: pri, F: f})
 	})
+	if atomic.LoadInt32(&p.closed) != 0 {
+		t.Stop()
+		return t
+	}
+	p.timers[t] = struct{}{}
+	return t
 }
 
 func (p *Work) NewTicker(d time.Duration, f func()) io.Closer {
-	t := newWorkTicker(p, d, f)
+	return p.NewTickerPriority(d, PriorityNormal, f)
+}
+
+func (p *Work) NewTickerPriority(d time.Duration, pri Priority, f func()) io.Closer {
+	t := newWorkTicker(p, d, pri, f)
+	if !p.track(t) {
+		return t
+	}
 	t.run()
 	return t
 }
 
+func (p *Work) track(c io.Closer) bool {
+	p.timerMu.Lock()
+	defer p.timerMu.Unlock()
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return false
+	}
+	p.tickers[c] = struct{}{}
+	return true
+}
+
+func (p *Work) untrack(c io.Closer) {
+	p.timerMu.Lock()
+	defer p.timerMu.Unlock()
+	delete(p.tickers, c)
+}
+
+// 关闭时停止所有未触发的定时器和ticker
+func (p *Work) stopTimers() {
+	p.timerMu.Lock()
+	tickers := make([]io.Closer, 0, len(p.tickers))
+	for c := range p.tickers {
+		tickers = append(tickers, c)
+	}
+	for t := range p.timers {
+		t.Stop()
+	}
+	p.timers = make(map[*time.Timer]struct{})
+	p.timerMu.Unlock()
+
+	for _, c := range tickers {
+		c.Close()
+	}
+}
+
 // worker长度超过maxLen就丢弃f
 func (p *Work) NewTryTicker(d time.Duration, maxLen int, f func()) *time.Ticker {
 	ticker := time.NewTicker(d)
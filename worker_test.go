@@ -0,0 +1,342 @@
+package natsrpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedWorkerKeyOrder(t *testing.T) {
+	w := NewShardedWorker(4)
+	w.Run()
+	defer w.Close()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		seqs = make(map[uint32][]int)
+	)
+	for i := 0; i < 1000; i++ {
+		key := uint32(i % 10)
+		n := i
+		wg.Add(1)
+		w.PostKey(key, func() {
+			defer wg.Done()
+			mu.Lock()
+			seqs[key] = append(seqs[key], n)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for key, seq := range seqs {
+		for i := 1; i < len(seq); i++ {
+			if seq[i] < seq[i-1] {
+				t.Errorf("key %d out of order: %d after %d", key, seq[i], seq[i-1])
+				return
+			}
+		}
+	}
+}
+
+func TestWorkerOverflow(t *testing.T) {
+	w := NewWorkerWithConfig(WorkerConfig{QueueSize: 2, Overflow: OverflowError})
+	w.Post(func() {})
+	w.Post(func() {})
+	if err := w.Post(func() {}); err != ErrWorkerFull {
+		t.Errorf("expect ErrWorkerFull, got %v", err)
+		return
+	}
+
+	w = NewWorkerWithConfig(WorkerConfig{QueueSize: 1, Overflow: OverflowBlockTimeout, Timeout: 10 * time.Millisecond})
+	w.Post(func() {})
+	if err := w.Post(func() {}); err != ErrWorkerTimeout {
+		t.Errorf("expect ErrWorkerTimeout, got %v", err)
+		return
+	}
+	// 没有设置Timeout时使用默认值，不会立即超时
+	w = NewWorkerWithConfig(WorkerConfig{QueueSize: 1, Overflow: OverflowBlockTimeout})
+	w.Post(func() {})
+	time.AfterFunc(10*time.Millisecond, w.Run)
+	if err := w.Post(func() {}); err != nil {
+		t.Errorf("expect block until run, got %v", err)
+		return
+	}
+	w.Close()
+
+	var ret []int
+	w = NewWorkerWithConfig(WorkerConfig{QueueSize: 2, Overflow: OverflowDropOldest})
+	for i := 0; i < 3; i++ {
+		n := i
+		if err := w.Post(func() { ret = append(ret, n) }); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if w.Len() != 2 {
+		t.Errorf("worker len not equal: %d", w.Len())
+		return
+	}
+	// 队列中为[1,2]，再次Post会丢弃1
+	done := make(chan struct{})
+	w.Post(func() { close(done) })
+	w.Run()
+	<-done
+	if len(ret) != 1 || ret[0] != 2 {
+		t.Errorf("expect oldest dropped, got %v", ret)
+	}
+}
+
+func TestWorkerOnDrop(t *testing.T) {
+	var dropped []int
+	post := func(w Worker, n int) {
+		w.PostTask(Task{F: func() {}, OnDrop: func() { dropped = append(dropped, n) }})
+	}
+	w := NewWorkerWithConfig(WorkerConfig{QueueSize: 1, Overflow: OverflowDropNewest})
+	post(w, 1)
+	post(w, 2)
+	w = NewWorkerWithConfig(WorkerConfig{QueueSize: 1, Overflow: OverflowDropOldest})
+	post(w, 3)
+	post(w, 4)
+	if len(dropped) != 2 || dropped[0] != 2 || dropped[1] != 3 {
+		t.Errorf("dropped not equal: %v", dropped)
+	}
+}
+
+func TestWorkerShutdownDrain(t *testing.T) {
+	w := NewWorker()
+	var n int32
+	for i := 0; i < 100; i++ {
+		w.Post(func() { atomic.AddInt32(&n, 1) })
+	}
+	ticker := w.NewTicker(time.Millisecond, func() {})
+	w.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	if atomic.LoadInt32(&n) != 100 {
+		t.Errorf("queued tasks not drained: %d", n)
+		return
+	}
+	if err := w.Post(func() {}); err != ErrWorkerClosed {
+		t.Errorf("expect ErrWorkerClosed, got %v", err)
+		return
+	}
+	ticker.Close()
+}
+
+// Close丢弃队列中的任务，正在执行的任务结束后不再执行新任务
+func TestWorkerCloseDiscard(t *testing.T) {
+	w := NewWorker().(*Work)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	w.Post(func() {
+		close(started)
+		<-block
+	})
+	var n int32
+	for i := 0; i < 100; i++ {
+		w.Post(func() { atomic.AddInt32(&n, 1) })
+	}
+	w.Run()
+	<-started
+	w.Close()
+	close(block)
+	<-w.exit
+	if atomic.LoadInt32(&n) != 0 {
+		t.Errorf("queued tasks run after close: %d", n)
+	}
+}
+
+// Shutdown超时返回时worker协程已经退出
+func TestWorkerShutdownNoRunAfter(t *testing.T) {
+	w := NewWorker()
+	var n int32
+	for i := 0; i < 100; i++ {
+		w.Post(func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&n, 1)
+		})
+	}
+	w.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+	ran := atomic.LoadInt32(&n)
+	time.Sleep(20 * time.Millisecond)
+	if ran == 100 || atomic.LoadInt32(&n) != ran {
+		t.Errorf("tasks run after shutdown: %d %d", ran, atomic.LoadInt32(&n))
+	}
+}
+
+// drain超时后剩余的任务调用OnDrop，Shutdown在worker协程退出后返回
+func TestWorkerShutdownDeadline(t *testing.T) {
+	w := NewWorker().(*Work)
+	var ran, dropped int32
+	for i := 0; i < 100; i++ {
+		w.PostTask(Task{F: func() {
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&ran, 1)
+		}, OnDrop: func() { atomic.AddInt32(&dropped, 1) }})
+	}
+	w.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-w.exit:
+	default:
+		t.Fatal("shutdown returned before worker exit")
+	}
+	r, d := atomic.LoadInt32(&ran), atomic.LoadInt32(&dropped)
+	if d == 0 || r+d != 100 || w.Len() != 0 {
+		t.Errorf("ran %d dropped %d len %d", r, d, w.Len())
+	}
+}
+
+// 没有Run过的worker关闭时丢弃队列中的任务
+func TestWorkerCloseNotRun(t *testing.T) {
+	w := NewWorker()
+	var dropped int32
+	for i := 0; i < 10; i++ {
+		w.PostTask(Task{F: func() { t.Error("task run after close") }, OnDrop: func() { atomic.AddInt32(&dropped, 1) }})
+	}
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	w.Run()
+	if atomic.LoadInt32(&dropped) != 10 || w.Len() != 0 {
+		t.Errorf("dropped not equal: %d %d", dropped, w.Len())
+	}
+}
+
+func TestWorkerPostCloseRace(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		w := NewWorkerWithConfig(WorkerConfig{QueueSize: 1})
+		w.Run()
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 100; k++ {
+					w.Post(func() {})
+				}
+			}()
+		}
+		w.Close()
+		wg.Wait()
+	}
+}
+
+func TestWorkerStats(t *testing.T) {
+	var observed int32
+	w := NewWorkerWithConfig(WorkerConfig{
+		SlowTask: time.Millisecond,
+		Observer: func(source string, wait, run time.Duration) {
+			if source == "ws:pb.ReqHello" {
+				atomic.AddInt32(&observed, 1)
+			}
+		},
+	})
+	w.Run()
+
+	done := make(chan struct{})
+	w.PostTask(Task{Source: "ws:pb.ReqHello", F: func() { time.Sleep(2 * time.Millisecond) }})
+	w.PostTask(Task{Source: "ws:pb.ReqHello", F: func() {}})
+	w.Post(func() { close(done) })
+	<-done
+	w.Close()
+
+	stat := w.Stats()["ws:pb.ReqHello"]
+	if stat.Count != 2 {
+		t.Errorf("stat count not equal: %d", stat.Count)
+		return
+	}
+	if stat.RunMax < 2*time.Millisecond {
+		t.Errorf("stat run max too small: %v", stat.RunMax)
+		return
+	}
+	if atomic.LoadInt32(&observed) != 2 {
+		t.Errorf("observer count not equal: %d", observed)
+	}
+}
+
+func TestWorkerPriority(t *testing.T) {
+	var ret []Priority
+	w := NewWorker()
+	for i := 0; i < 3; i++ {
+		w.PostPriority(PriorityLow, func() { ret = append(ret, PriorityLow) })
+		w.PostPriority(PriorityNormal, func() { ret = append(ret, PriorityNormal) })
+		w.PostPriority(PriorityHigh, func() { ret = append(ret, PriorityHigh) })
+	}
+	w.Run()
+	w.Shutdown(context.Background())
+
+	expect := []Priority{PriorityHigh, PriorityHigh, PriorityHigh, PriorityNormal, PriorityNormal, PriorityNormal, PriorityLow, PriorityLow, PriorityLow}
+	for i := range expect {
+		if ret[i] != expect[i] {
+			t.Errorf("strict order not equal: %v", ret)
+			return
+		}
+	}
+
+	ret = ret[:0]
+	w = NewWorkerWithConfig(WorkerConfig{Schedule: ScheduleWeighted, Weights: [3]int{2, 1, 1}})
+	for i := 0; i < 4; i++ {
+		w.PostPriority(PriorityLow, func() { ret = append(ret, PriorityLow) })
+		w.PostPriority(PriorityHigh, func() { ret = append(ret, PriorityHigh) })
+	}
+	w.Run()
+	w.Shutdown(context.Background())
+
+	// 每轮执行2个high、1个low
+	expect = []Priority{PriorityHigh, PriorityHigh, PriorityLow, PriorityHigh, PriorityHigh, PriorityLow, PriorityLow, PriorityLow}
+	for i := range expect {
+		if ret[i] != expect[i] {
+			t.Errorf("weighted order not equal: %v", ret)
+			return
+		}
+	}
+}
+
+func TestWorkerPostCtx(t *testing.T) {
+	w := NewWorker()
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran int32
+	w.PostCtx(ctx, func(ctx context.Context) { atomic.AddInt32(&ran, 1) })
+	cancel()
+
+	done := make(chan context.Context, 1)
+	w.PostCtx(context.Background(), func(ctx context.Context) { done <- ctx })
+	w.Run()
+
+	taskCtx := <-done
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Error("task with cancelled ctx should be skipped")
+		return
+	}
+	if w.Stats()[SourceDefault].Skipped != 1 {
+		t.Errorf("skipped not equal: %d", w.Stats()[SourceDefault].Skipped)
+		return
+	}
+	if taskCtx.Err() == nil {
+		t.Error("task ctx should be done after shutdown")
+	}
+}
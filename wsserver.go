@@ -0,0 +1,316 @@
+package natsrpc
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wwqdrh/gokit/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultWSMaxMsgLen  = 512 //与之前使用的gokit ws相同
+	DefaultWSPongWait   = 60 * time.Second
+	DefaultWSSendBuffer = 256
+	wsWriteWait         = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// websocket监听，设置tls后为wss
+// 连接断开时ReadMsg返回错误，每条消息为一个websocket帧，帧类型与之前的实现一致为TextMessage
+// 默认行为与之前使用的gokit ws相同：单个消息最大512字节，超过时断开；发送队列满时WriteMsg阻塞；
+// 每pongWait*9/10发送一次ping，超过pongWait没有收到任何数据时断开
+// 可以通过SetMaxMsgLen、SetCloseOnFull、SetPongWait修改
+type WSServer struct {
+	addr       string
+	tlsConf    *tls.Config
+	maxMsgLen  int64
+	pongWait   time.Duration
+	sendBuffer int
+	closeFull  bool
+	onInvoker  func(conn Conn) ConnInvoker
+
+	connid int32
+
+	mu     sync.Mutex
+	ln     net.Listener
+	svr    *http.Server
+	conns  map[*WSConn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewWSServer(addr string, invoker func(conn Conn) ConnInvoker) *WSServer {
+	return &WSServer{
+		addr:       addr,
+		maxMsgLen:  DefaultWSMaxMsgLen,
+		pongWait:   DefaultWSPongWait,
+		sendBuffer: DefaultWSSendBuffer,
+		onInvoker:  invoker,
+		conns:      make(map[*WSConn]struct{}),
+	}
+}
+
+// 需要在Start之前调用
+func (p *WSServer) SetTLS(conf *tls.Config) {
+	p.tlsConf = conf
+}
+
+func (p *WSServer) SetMaxMsgLen(n int64) {
+	p.maxMsgLen = n
+}
+
+// 为0时不发送ping也不设置读超时，由Mgr的心跳处理空闲连接，需要在Start之前调用
+func (p *WSServer) SetPongWait(d time.Duration) {
+	p.pongWait = d
+}
+
+// 每个连接的发送队列长度，需要在Start之前调用
+func (p *WSServer) SetSendBuffer(n int) {
+	if n > 0 {
+		p.sendBuffer = n
+	}
+}
+
+// 为true时发送队列满时断开连接并返回ErrConnSendFull，不阻塞调用方(worker)，需要在Start之前调用
+func (p *WSServer) SetCloseOnFull(b bool) {
+	p.closeFull = b
+}
+
+func (p *WSServer) Start() error {
+	ln, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		logger.DefaultLogger.Error("WSServer listen failed", zap.String("addr", p.addr), zap.Error(err))
+		return err
+	}
+	if p.tlsConf != nil {
+		ln = tls.NewListener(ln, p.tlsConf)
+	}
+	p.ln = ln
+	p.svr = &http.Server{Handler: p}
+	go func() {
+		err := p.svr.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			logger.DefaultLogger.Errorx("WSServer Serve: %s", nil, err.Error())
+		}
+	}()
+	return nil
+}
+
+func (p *WSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.DefaultLogger.Errorx("WSServer upgrade: %s", nil, err.Error())
+		return
+	}
+	wsc := newWSConn(conn, p.maxMsgLen, p.pongWait, p.sendBuffer, p.closeFull)
+	wsc.id = atomic.AddInt32(&p.connid, 1)
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.conns[wsc] = struct{}{}
+	p.wg.Add(1)
+	p.mu.Unlock()
+	defer p.wg.Done()
+
+	var invoker ConnInvoker
+	if p.onInvoker != nil {
+		invoker = p.onInvoker(wsc)
+	}
+	if invoker != nil {
+		invoker.OnNew()
+	}
+	wsc.Close()
+	p.mu.Lock()
+	delete(p.conns, wsc)
+	p.mu.Unlock()
+	if invoker != nil {
+		invoker.OnClose()
+	}
+}
+
+// 停止监听并断开所有连接
+func (p *WSServer) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	if p.svr != nil {
+		p.svr.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *WSServer) ListenAddr() *net.TCPAddr {
+	return p.ln.Addr().(*net.TCPAddr)
+}
+
+type WSConn struct {
+	conn      *websocket.Conn
+	id        int32
+	pongWait  time.Duration
+	closeFull bool
+	done      chan struct{} //写协程出错退出时关闭，唤醒阻塞的WriteMsg
+
+	mu        sync.Mutex
+	send      chan []byte
+	closeFlag bool
+	onPong    atomic.Value //func([]byte)
+}
+
+func newWSConn(conn *websocket.Conn, maxMsgLen int64, pongWait time.Duration, sendBuffer int, closeFull bool) *WSConn {
+	p := &WSConn{
+		conn:      conn,
+		pongWait:  pongWait,
+		closeFull: closeFull,
+		done:      make(chan struct{}),
+		send:      make(chan []byte, sendBuffer),
+	}
+	conn.SetReadLimit(maxMsgLen)
+	p.extendDeadline()
+	conn.SetPongHandler(func(data string) error {
+		p.extendDeadline()
+		if f, ok := p.onPong.Load().(func([]byte)); ok {
+			f([]byte(data))
+		}
+		return nil
+	})
+	go p.writeLoop()
+	return p
+}
+
+func (p *WSConn) ReadMsg() ([]byte, error) {
+	_, data, err := p.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	p.extendDeadline()
+	return data, nil
+}
+
+func (p *WSConn) extendDeadline() {
+	if p.pongWait > 0 {
+		p.conn.SetReadDeadline(time.Now().Add(p.pongWait))
+	}
+}
+
+// 发送队列满时阻塞，直到有空位或连接出错，closeFull时断开连接
+func (p *WSConn) WriteMsg(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closeFlag {
+		return ErrConnClosed
+	}
+	if p.closeFull {
+		select {
+		case p.send <- data:
+			return nil
+		default:
+			p.closeLocked()
+			return ErrConnSendFull
+		}
+	}
+	select {
+	case p.send <- data:
+		return nil
+	case <-p.done:
+		return ErrConnClosed
+	}
+}
+
+func (p *WSConn) writeLoop() {
+	var tick <-chan time.Time
+	if p.pongWait > 0 {
+		ticker := time.NewTicker(p.pongWait * 9 / 10)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	defer p.conn.Close()
+	for {
+		select {
+		case data, ok := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				p.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := p.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				p.abort()
+				return
+			}
+		case <-tick:
+			p.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := p.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				p.abort()
+				return
+			}
+		}
+	}
+}
+
+// 写出错时先唤醒阻塞的WriteMsg再关闭，丢弃队列中的消息
+func (p *WSConn) abort() {
+	close(p.done)
+	p.Close()
+	for range p.send {
+	}
+}
+
+// 协议层ping，对端自动回复相同数据的pong
+func (p *WSConn) Ping(data []byte) error {
+	return p.conn.WriteControl(websocket.PingMessage, data, time.Now().Add(wsWriteWait))
+}
+
+// 收到pong时在读协程中调用f
+func (p *WSConn) SetPongHandler(f func(data []byte)) {
+	p.onPong.Store(f)
+}
+
+func (p *WSConn) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *WSConn) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+func (p *WSConn) ID() int32 {
+	return p.id
+}
+
+// 发送完队列中的消息后断开
+func (p *WSConn) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+}
+
+func (p *WSConn) closeLocked() {
+	if p.closeFlag {
+		return
+	}
+	p.closeFlag = true
+	close(p.send)
+}
@@ -0,0 +1,215 @@
+package natsrpc
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsTestInvoker struct {
+	conn   Conn
+	closed chan struct{}
+}
+
+func (p *wsTestInvoker) OnNew() {
+	for {
+		data, err := p.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		p.conn.WriteMsg(data)
+	}
+}
+
+func (p *wsTestInvoker) OnClose() {
+	close(p.closed)
+}
+
+func startWSTestServer(t *testing.T, setup func(*WSServer)) (*WSServer, chan *wsTestInvoker) {
+	invokers := make(chan *wsTestInvoker, 4)
+	svr := NewWSServer("127.0.0.1:0", func(conn Conn) ConnInvoker {
+		v := &wsTestInvoker{conn: conn, closed: make(chan struct{})}
+		invokers <- v
+		return v
+	})
+	if setup != nil {
+		setup(svr)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return svr, invokers
+}
+
+func waitClosed(t *testing.T, v *wsTestInvoker, msg string) {
+	select {
+	case <-v.closed:
+	case <-time.After(2 * time.Second):
+		t.Error(msg)
+	}
+}
+
+func TestWSServerMaxMsgLen(t *testing.T) {
+	svr, invokers := startWSTestServer(t, func(s *WSServer) { s.SetMaxMsgLen(16) })
+	defer svr.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+svr.ListenAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	v := <-invokers
+
+	ws.WriteMessage(websocket.TextMessage, []byte("hello"))
+	typ, data, err := ws.ReadMessage()
+	if err != nil || typ != websocket.TextMessage || string(data) != "hello" {
+		t.Fatalf("echo not equal: %d %q %v", typ, data, err)
+	}
+	// 超过最大长度时断开
+	ws.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte("x"), 17))
+	waitClosed(t, v, "oversize message not closed")
+}
+
+// SetCloseOnFull时客户端不读取，发送队列满后断开，WriteMsg不阻塞
+func TestWSServerSendFull(t *testing.T) {
+	svr, invokers := startWSTestServer(t, func(s *WSServer) {
+		s.SetSendBuffer(4)
+		s.SetCloseOnFull(true)
+	})
+	defer svr.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+svr.ListenAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	v := <-invokers
+
+	data := bytes.Repeat([]byte("x"), 64<<10)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := v.conn.WriteMsg(data)
+		if err == ErrConnSendFull {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expect ErrConnSendFull, got %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("send queue never full")
+		}
+	}
+	if err := v.conn.WriteMsg(data); err != ErrConnClosed {
+		t.Errorf("expect ErrConnClosed, got %v", err)
+	}
+	ws.Close()
+	waitClosed(t, v, "conn not closed after send full")
+}
+
+// 默认发送队列满时WriteMsg阻塞，连接出错后返回ErrConnClosed
+func TestWSServerSendBlock(t *testing.T) {
+	svr, invokers := startWSTestServer(t, func(s *WSServer) { s.SetSendBuffer(1) })
+	defer svr.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+svr.ListenAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := <-invokers
+
+	var sent int32
+	done := make(chan error, 1)
+	go func() {
+		data := bytes.Repeat([]byte("x"), 64<<10)
+		for {
+			if err := v.conn.WriteMsg(data); err != nil {
+				done <- err
+				return
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+	}()
+	// 等待发送阻塞
+	last := int32(-1)
+	for atomic.LoadInt32(&sent) != last {
+		last = atomic.LoadInt32(&sent)
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("write not blocked: %v", err)
+	default:
+	}
+
+	ws.Close()
+	select {
+	case err := <-done:
+		if err != ErrConnClosed {
+			t.Errorf("expect ErrConnClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked write not woken after conn error")
+	}
+	waitClosed(t, v, "conn not closed after write error")
+}
+
+// 客户端读取时自动回复ping，不读取时超过pongWait断开
+func TestWSServerPongWait(t *testing.T) {
+	svr, invokers := startWSTestServer(t, func(s *WSServer) { s.SetPongWait(100 * time.Millisecond) })
+	defer svr.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+svr.ListenAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	v := <-invokers
+	var mute int32
+	pings := make(chan struct{}, 16)
+	ws.SetPingHandler(func(data string) error {
+		if atomic.LoadInt32(&mute) != 0 {
+			return nil
+		}
+		pings <- struct{}{}
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case <-v.closed:
+		t.Fatal("closed while answering pings")
+	default:
+	}
+	if len(pings) < 2 {
+		t.Errorf("pings not sent: %d", len(pings))
+	}
+
+	atomic.StoreInt32(&mute, 1)
+	waitClosed(t, v, "conn not closed without pong")
+}
+
+func TestWSServerClose(t *testing.T) {
+	svr, invokers := startWSTestServer(t, func(s *WSServer) { s.SetPongWait(0) })
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+svr.ListenAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	v := <-invokers
+	svr.Close()
+	waitClosed(t, v, "conn not closed after server close")
+	if _, _, err := websocket.DefaultDialer.Dial("ws://"+svr.ListenAddr().String(), nil); err == nil {
+		t.Error("dial after close")
+	}
+}